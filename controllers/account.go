@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-redis/redis"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/mailer"
+	"github.com/jcprz/jwtapp/models"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type changeEmailRequest struct {
+	Password string `json:"password"`
+	NewEmail string `json:"new_email"`
+}
+
+// authenticatedUser loads the user the bearer token belongs to and checks
+// their current password. The check counts towards the same failed-login
+// lockout as Login, so a stolen token can't be used to guess the password.
+// On failure the response has been written.
+func authenticatedUser(db *sql.DB, redis *redis.Client, w http.ResponseWriter, r *http.Request, password string) (models.User, jwt.MapClaims, bool) {
+	principal, err := requestPrincipal(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return models.User{}, nil, false
+	}
+
+	claims := principal.Claims
+	userID, err := principal.UserID()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return models.User{}, nil, false
+	}
+
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.GetByID(db, userID)
+	if err == nil {
+		user.Password, err = userRepo.GetPassword(db, userID)
+	}
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return models.User{}, nil, false
+	}
+	if err != nil {
+		log.Printf("Error looking up user %d: %v", userID, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+		return models.User{}, nil, false
+	}
+
+	if password == "" {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
+		return models.User{}, nil, false
+	}
+
+	if status, message, retryAfter := loginBlock(redis, r, user.Email); status != http.StatusOK {
+		setRetryAfter(w, retryAfter)
+		utils.RespondWithError(w, status, message)
+		return models.User{}, nil, false
+	}
+
+	if !verifyLoginPassword(db, user, password) {
+		recordLoginFailure(redis, r, user.Email)
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
+		return models.User{}, nil, false
+	}
+
+	resetLoginFailures(redis, r, user.Email)
+
+	user.Password = ""
+	return user, claims, true
+}
+
+// restartSession revokes every session of user, the current one included, and
+// issues a fresh token pair for the caller to carry on with. No tokens are
+// issued when the user may no longer log in because their email address
+// needs to be verified.
+func restartSession(db *sql.DB, redis *redis.Client, user models.User, claims jwt.MapClaims) (models.JWT, error) {
+	if err := revokeUserSessions(db, redis, user.ID); err != nil {
+		return models.JWT{}, err
+	}
+
+	// The cutoff has a one second resolution, so also make sure the current
+	// token is gone.
+	if err := denyAccessToken(redis, claims); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+	}
+
+	allowed, limited := emailVerificationPolicy(user)
+	if !allowed {
+		return models.JWT{}, nil
+	}
+	// The user proved nothing new, so the session keeps how it started.
+	amr := utils.ClaimAMR(claims)
+	if limited {
+		return issueAccessToken(db, user, "", amr)
+	}
+
+	return issueTokens(db, user, "", "", amr)
+}
+
+func respondWithSession(w http.ResponseWriter, jwt models.JWT) {
+	if jwt.Token == "" {
+		utils.ResponseJSON(w, http.StatusOK, "Verify your email address to log in again")
+		return
+	}
+
+	w.Header().Set("Authorization", jwt.Token)
+	utils.ResponseJSON(w, http.StatusOK, jwt)
+}
+
+// ChangePassword replaces the password of the logged in user, who has to
+// confirm the current one. All other sessions are signed out; the response
+// carries new tokens for this one. The user is notified by mail. Meant to be
+// wrapped by TokenVerifyMiddleware.
+func (c Controller) ChangePassword(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req changePasswordRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.NewPassword == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "New password is missing.")
+			return
+		}
+
+		user, claims, ok := authenticatedUser(db, redis, w, r, req.CurrentPassword)
+		if !ok {
+			return
+		}
+
+		if !validNewPassword(w, "new_password", req.NewPassword, user.Email) {
+			return
+		}
+
+		hash, err := utils.HashPassword(req.NewPassword)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		userRepo := userRepository.UserRepository{}
+		if err := userRepo.UpdatePassword(db, user.ID, hash); err != nil {
+			log.Printf("Error updating password of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		sendNotification(mail, user.Email, "password_changed", nil)
+
+		jwt, err := restartSession(db, redis, user, claims)
+		if err != nil {
+			log.Printf("Error restarting session of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		respondWithSession(w, jwt)
+	}
+}
+
+// ChangeEmail moves the logged in user to a new email address, which has to
+// be verified again. The user confirms with their password and the old
+// address is notified. All other sessions are signed out, since their tokens
+// carry the old address. Meant to be wrapped by TokenVerifyMiddleware.
+func (c Controller) ChangeEmail(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	verifyURL := appBaseURL() + "/verify-email"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req changeEmailRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.NewEmail == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "New email is missing.")
+			return
+		}
+
+		user, claims, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		if req.NewEmail == user.Email {
+			utils.RespondWithError(w, http.StatusBadRequest, "New email is the same as the current one.")
+			return
+		}
+
+		userRepo := userRepository.UserRepository{}
+		exists, err := userRepo.EmailExists(db, req.NewEmail)
+		if err != nil {
+			log.Printf("Error checking email of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if exists {
+			utils.RespondWithError(w, http.StatusConflict, "Email is already in use.")
+			return
+		}
+
+		oldEmail := user.Email
+		user, err = userRepo.UpdateEmail(db, redis, user, req.NewEmail)
+		if err != nil {
+			log.Printf("Error updating email of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		sendNotification(mail, oldEmail, "email_changed", map[string]string{"NewEmail": user.Email})
+
+		if utils.EmailVerificationMode() != utils.EmailVerificationOff {
+			if err := sendVerificationEmail(redis, mail, user, verifyURL); err != nil {
+				log.Printf("Error sending verification email to user %d: %v", user.ID, err)
+			}
+		}
+
+		jwt, err := restartSession(db, redis, user, claims)
+		if err != nil {
+			log.Printf("Error restarting session of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		respondWithSession(w, jwt)
+	}
+}
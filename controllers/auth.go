@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/golang-jwt/jwt/v5"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header,
+// or an empty string when the header is missing or malformed.
+func bearerToken(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) == 2 && parts[0] == "Bearer" {
+		return parts[1]
+	}
+
+	return ""
+}
+
+var errNoPrincipal = errors.New("request has no verified access token")
+
+// requestPrincipal returns who is calling, as TokenVerifyMiddleware or
+// RequireScope put it in the request context. Handlers that aren't wrapped
+// by either get an error rather than an unverified token.
+func requestPrincipal(r *http.Request) (utils.Principal, error) {
+	principal, ok := utils.PrincipalFromContext(r.Context())
+	if !ok {
+		return utils.Principal{}, errNoPrincipal
+	}
+
+	return principal, nil
+}
+
+// requestUserID returns the ID of the user whose access token authorizes the
+// request.
+func requestUserID(r *http.Request) (int, error) {
+	principal, err := requestPrincipal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return principal.UserID()
+}
+
+// verifyToken validates an access token and checks it against the revocation
+// state in Redis. On failure it returns the status and message to send back.
+func verifyToken(redis *redis.Client, tokenStr string) (jwt.MapClaims, int, string) {
+	claims, err := utils.ParseToken(tokenStr)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err.Error()
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, http.StatusUnauthorized, "Invalid token"
+	}
+
+	denied, err := tokenRepo.IsTokenDenied(redis, jti)
+	if err != nil {
+		log.Printf("Error checking token denylist: %v", err)
+		return nil, http.StatusServiceUnavailable, "Unable to verify token"
+	}
+	if denied {
+		return nil, http.StatusUnauthorized, "Token has been revoked"
+	}
+
+	sub, _ := claims.GetSubject()
+	cutoff, err := tokenRepo.TokensRevokedBefore(redis, sub)
+	if err != nil {
+		log.Printf("Error checking token revocation: %v", err)
+		return nil, http.StatusServiceUnavailable, "Unable to verify token"
+	}
+
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil || iat.Time.Before(cutoff) {
+		return nil, http.StatusUnauthorized, "Token has been revoked"
+	}
+
+	return claims, http.StatusOK, ""
+}
+
+// denyAccessToken puts the token described by claims on the denylist for the
+// rest of its lifetime.
+func denyAccessToken(redis *redis.Client, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return err
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	return tokenRepo.DenyToken(redis, jti, time.Until(exp.Time))
+}
+
+// revokeUserSessions revokes every refresh token and every access token issued
+// so far to the given user.
+func revokeUserSessions(db *sql.DB, redis *redis.Client, userID int) error {
+	tokenRepo := tokenRepository.TokenRepository{}
+
+	if err := tokenRepo.RevokeUserRefreshTokens(db, userID); err != nil {
+		return err
+	}
+
+	return tokenRepo.RevokeTokensIssuedBefore(redis, strconv.Itoa(userID), time.Now(), utils.AccessTokenTTL())
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jcprz/jwtapp/models"
+	clientRepository "github.com/jcprz/jwtapp/repository/client"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+var errInvalidClient = errors.New("invalid client")
+
+// clientCredentials returns the client ID and secret of a request, taken from
+// HTTP Basic auth (client_secret_basic) or the form body
+// (client_secret_post). Basic credentials are form-encoded as RFC 6749
+// requires.
+func clientCredentials(r *http.Request) (string, string, bool, error) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		if r.PostForm.Get("client_secret") != "" {
+			return "", "", false, errInvalidClient
+		}
+
+		id, err := url.QueryUnescape(id)
+		if err != nil {
+			return "", "", false, errInvalidClient
+		}
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return "", "", false, errInvalidClient
+		}
+
+		return id, secret, true, nil
+	}
+
+	secret := r.PostForm.Get("client_secret")
+	return r.PostForm.Get("client_id"), secret, secret != "", nil
+}
+
+// authenticateClient works out which client is calling. Confidential clients,
+// the ones with a secret, must present it. Public clients only identify
+// themselves with client_id, so the returned bool tells whether the client
+// actually proved who it is. A request without any client_id returns an empty
+// client and no error.
+func authenticateClient(db *sql.DB, r *http.Request) (models.Client, bool, error) {
+	clientID, secret, hasSecret, err := clientCredentials(r)
+	if err != nil {
+		return models.Client{}, false, err
+	}
+
+	if clientID == "" {
+		if hasSecret {
+			return models.Client{}, false, errInvalidClient
+		}
+		return models.Client{}, false, nil
+	}
+
+	clientRepo := clientRepository.ClientRepository{}
+	client, err := clientRepo.GetByClientID(db, clientID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up OAuth client: %v", err)
+			return models.Client{}, false, err
+		}
+		return models.Client{}, false, errInvalidClient
+	}
+
+	hashes, err := clientRepo.GetSecretHashes(db, clientID)
+	if err != nil {
+		log.Printf("Error looking up OAuth client secrets: %v", err)
+		return models.Client{}, false, err
+	}
+
+	if len(hashes) == 0 {
+		if hasSecret {
+			return models.Client{}, false, errInvalidClient
+		}
+		return client, false, nil
+	}
+
+	if !hasSecret {
+		return models.Client{}, false, errInvalidClient
+	}
+
+	for _, hash := range hashes {
+		if utils.ComparePasswords(hash, []byte(secret)) {
+			return client, true, nil
+		}
+	}
+
+	return models.Client{}, false, errInvalidClient
+}
+
+// respondWithClientError sends the response for a failed client
+// authentication.
+func respondWithClientError(w http.ResponseWriter, r *http.Request, err error) {
+	if err != errInvalidClient {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	if _, _, ok := r.BasicAuth(); ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+}
+
+// grantScope checks the requested scope against the scopes the client may
+// ask for. An empty request gets all of them.
+func grantScope(client models.Client, requested string) (string, bool) {
+	if requested == "" {
+		return strings.Join(client.AllowedScopes, " "), true
+	}
+
+	for _, scope := range strings.Fields(requested) {
+		allowed := false
+		for _, a := range client.AllowedScopes {
+			if a == scope {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", false
+		}
+	}
+
+	return strings.Join(strings.Fields(requested), " "), true
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+var errTokenStateUnavailable = errors.New("unable to verify token")
+
+// Introspect implements token introspection (RFC 7662) for resource servers
+// that can't verify our tokens themselves. Callers authenticate as a
+// confidential client. Access tokens go through the same checks as
+// TokenVerifyMiddleware, revocation included; refresh tokens are looked up in
+// the database.
+func (c Controller) Introspect(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed request.")
+			return
+		}
+
+		_, authenticated, err := authenticateClient(db, r)
+		if err == nil && !authenticated {
+			err = errInvalidClient
+		}
+		if err != nil {
+			respondWithClientError(w, r, err)
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required.")
+			return
+		}
+
+		// The hint only decides which kind of token is tried first.
+		lookups := []func() (models.Introspection, error){
+			func() (models.Introspection, error) { return introspectAccessToken(redis, token) },
+			func() (models.Introspection, error) { return introspectRefreshToken(db, token) },
+		}
+		if r.PostForm.Get("token_type_hint") == "refresh_token" {
+			lookups[0], lookups[1] = lookups[1], lookups[0]
+		}
+
+		result := models.Introspection{}
+		for _, lookup := range lookups {
+			result, err = lookup()
+			if err != nil {
+				respondWithOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+				return
+			}
+			if result.Active {
+				break
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		utils.ResponseJSON(w, http.StatusOK, result)
+	}
+}
+
+func introspectAccessToken(redis *redis.Client, token string) (models.Introspection, error) {
+	claims, status, _ := verifyToken(redis, token)
+	if status == http.StatusServiceUnavailable {
+		return models.Introspection{}, errTokenStateUnavailable
+	}
+	if status != http.StatusOK {
+		return models.Introspection{}, nil
+	}
+
+	result := models.Introspection{Active: true, TokenType: "Bearer"}
+	result.Sub, _ = claims.GetSubject()
+	result.Iss, _ = claims.GetIssuer()
+	result.Scope, _ = claims["scope"].(string)
+	result.ClientID, _ = claims["client_id"].(string)
+	result.Jti, _ = claims["jti"].(string)
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.Exp = exp.Unix()
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		result.Iat = iat.Unix()
+	}
+
+	return result, nil
+}
+
+func introspectRefreshToken(db *sql.DB, token string) (models.Introspection, error) {
+	tokenRepo := tokenRepository.TokenRepository{}
+
+	stored, err := tokenRepo.FindRefreshToken(db, utils.HashToken(token))
+	if err == sql.ErrNoRows {
+		return models.Introspection{}, nil
+	}
+	if err != nil {
+		log.Printf("Error looking up refresh token: %v", err)
+		return models.Introspection{}, err
+	}
+
+	if stored.Used || stored.Revoked || time.Now().After(stored.ExpiresAt) {
+		return models.Introspection{}, nil
+	}
+
+	return models.Introspection{
+		Active:    true,
+		Sub:       strconv.Itoa(stored.UserID),
+		ClientID:  stored.ClientID,
+		Exp:       stored.ExpiresAt.Unix(),
+		Iss:       utils.Issuer(),
+		TokenType: "refresh_token",
+	}, nil
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// JWKS publishes the public signing keys at /.well-known/jwks.json so other
+// services can verify our tokens without the shared secret.
+func (c Controller) JWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set, err := utils.JWKS()
+
+		if err != nil {
+			log.Printf("Error building JWKS: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		utils.ResponseJSON(w, http.StatusOK, set)
+	}
+}
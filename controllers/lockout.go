@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	lockoutRepository "github.com/jcprz/jwtapp/repository/lockout"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// loginSubjects returns the lockout subjects of a login attempt: the account,
+// by email address, and the client IP.
+func loginSubjects(r *http.Request, email string) (string, string) {
+	return "account:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + utils.ClientIP(r)
+}
+
+// loginBlock checks whether a login attempt may go ahead. If not it returns
+// the status to answer with, 423 for a locked account and 429 otherwise,
+// along with a message and how long to wait. Redis being down blocks logins
+// too, rather than letting unlimited guesses through.
+func loginBlock(redis *redis.Client, r *http.Request, email string) (int, string, time.Duration) {
+	lockoutRepo := lockoutRepository.LockoutRepository{}
+	account, ip := loginSubjects(r, email)
+
+	for _, subject := range []string{account, ip} {
+		reason, remaining, err := lockoutRepo.Blocked(redis, subject)
+		if err != nil {
+			log.Printf("Error checking login lockout: %v", err)
+			return http.StatusServiceUnavailable, "Unable to log in right now.", 0
+		}
+		if reason == "" || remaining <= 0 {
+			continue
+		}
+
+		if reason == lockoutRepository.BlockLockout && subject == account {
+			return http.StatusLocked, "Account is temporarily locked after too many failed logins.", remaining
+		}
+		return http.StatusTooManyRequests, "Too many failed logins, try again later.", remaining
+	}
+
+	return http.StatusOK, "", 0
+}
+
+// recordLoginFailure counts a failed login against the account and the
+// client IP and blocks them once they cross their thresholds.
+func recordLoginFailure(redis *redis.Client, r *http.Request, email string) {
+	lockoutRepo := lockoutRepository.LockoutRepository{}
+	account, ip := loginSubjects(r, email)
+
+	throttles := map[string]utils.LoginThrottle{
+		account: utils.AccountLoginThrottle(),
+		ip:      utils.IPLoginThrottle(),
+	}
+
+	for subject, throttle := range throttles {
+		failures, err := lockoutRepo.RecordFailure(redis, subject, throttle.Window)
+		if err != nil {
+			log.Printf("Error recording failed login: %v", err)
+			continue
+		}
+
+		switch {
+		case throttle.Locks(failures):
+			log.Printf("Locking %s out for %s after %d failed logins", subject, throttle.LockoutDuration, failures)
+			err = lockoutRepo.Block(redis, subject, lockoutRepository.BlockLockout, throttle.LockoutDuration)
+		case throttle.Delay(failures) > 0:
+			err = lockoutRepo.Block(redis, subject, lockoutRepository.BlockDelay, throttle.Delay(failures))
+		}
+		if err != nil {
+			log.Printf("Error blocking %s: %v", subject, err)
+		}
+	}
+}
+
+// resetLoginFailures clears the account's failures after a successful login.
+// The IP's failures are left to expire, or a client could clear them between
+// guesses by logging into an account of its own.
+func resetLoginFailures(redis *redis.Client, r *http.Request, email string) {
+	lockoutRepo := lockoutRepository.LockoutRepository{}
+	account, _ := loginSubjects(r, email)
+
+	if err := lockoutRepo.Reset(redis, account); err != nil {
+		log.Printf("Error resetting failed logins: %v", err)
+	}
+}
+
+// setRetryAfter sets Retry-After in whole seconds, rounded up.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	}
+}
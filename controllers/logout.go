@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-redis/redis"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// Logout revokes the access token used to call it and, when one is passed in
+// the body, the refresh token of the same session. Meant to be wrapped by
+// TokenVerifyMiddleware.
+func (c Controller) Logout(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req refreshRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		principal, err := requestPrincipal(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		if err := denyAccessToken(redis, principal.Claims); err != nil {
+			log.Printf("Error revoking access token: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		if req.RefreshToken != "" {
+			tokenRepo := tokenRepository.TokenRepository{}
+
+			stored, err := tokenRepo.FindRefreshToken(db, utils.HashToken(req.RefreshToken))
+
+			// Only let callers end their own sessions.
+			if err == nil && strconv.Itoa(stored.UserID) == principal.Subject {
+				if err := tokenRepo.RevokeTokenFamily(db, stored.FamilyID); err != nil {
+					log.Printf("Error revoking refresh token family: %v", err)
+					utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+					return
+				}
+			}
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, "Logged out")
+	}
+}
+
+// LogoutAll revokes every access and refresh token of the calling user,
+// signing them out of all devices. Meant to be wrapped by
+// TokenVerifyMiddleware.
+func (c Controller) LogoutAll(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := requestPrincipal(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		userID, err := principal.UserID()
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		if err := revokeUserSessions(db, redis, userID); err != nil {
+			log.Printf("Error revoking sessions of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		// The cutoff has a one second resolution, so also make sure the
+		// current token is gone.
+		if err := denyAccessToken(redis, principal.Claims); err != nil {
+			log.Printf("Error revoking access token: %v", err)
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, "Logged out from all sessions")
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/mailer"
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+type magicLinkRequest struct {
+	Email    string `json:"email"`
+	ClientID string `json:"client_id"`
+	Nonce    string `json:"nonce"`
+}
+
+type magicLinkCallbackRequest struct {
+	Token string `json:"token"`
+}
+
+type magicLinkPage struct {
+	Action string
+	Token  string
+}
+
+// MagicLink mails a link that logs the user in without a password. Like
+// ForgotPassword, the response doesn't reveal whether the address has an
+// account. ClientID and Nonce are optional and work as they do for Login.
+// The link points at APP_BASE_URL, which has to be set.
+func (c Controller) MagicLink(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	callbackURL := appBaseURL() + "/login/magic-link/callback"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req magicLinkRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Email == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Email is missing.")
+			return
+		}
+
+		go sendMagicLinkEmail(db, redis, mail, req, callbackURL)
+
+		utils.ResponseJSON(w, http.StatusAccepted, "If the address belongs to an account, a login link is on its way")
+	}
+}
+
+func sendMagicLinkEmail(db *sql.DB, redis *redis.Client, mail mailer.Mailer, req magicLinkRequest, callbackURL string) {
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.Login(db, redis, models.User{Email: req.Email})
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up user for magic link: %v", err)
+		}
+		return
+	}
+
+	token, jti, err := utils.GenerateMagicLinkToken(user, req.ClientID, req.Nonce)
+	if err != nil {
+		log.Printf("Error generating magic link token: %v", err)
+		return
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	if err := tokenRepo.SaveMagicLink(redis, jti, utils.MagicLinkTTL()); err != nil {
+		log.Printf("Error storing magic link: %v", err)
+		return
+	}
+
+	msg, err := mailer.Render(user.Email, "magic_link", map[string]string{
+		"Link":     callbackURL + "?token=" + url.QueryEscape(token),
+		"ValidFor": validFor(utils.MagicLinkTTL()),
+	})
+	if err == nil {
+		err = mail.Send(msg)
+	}
+	if err != nil {
+		log.Printf("Error sending magic link to user %d: %v", user.ID, err)
+	}
+}
+
+// MagicLinkCallback exchanges a login link for the same response Login
+// gives, an MFA challenge included when the user has a second factor.
+// Opening the link is a GET, which only shows a page that POSTs the token
+// back: mail scanners and link previews fetch links too, and must not use
+// up the single-use token. The token is redeemed on POST, from the form or
+// a JSON body. Using the link proves the user receives mail at their
+// address, so it is marked verified.
+func (c Controller) MagicLinkCallback(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			renderMagicLinkPage(w, r)
+			return
+		}
+
+		var req magicLinkCallbackRequest
+
+		if isFormPost(r) {
+			req.Token = r.PostFormValue("token")
+		} else {
+			json.NewDecoder(r.Body).Decode(&req)
+		}
+
+		if req.Token == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Token is missing.")
+			return
+		}
+
+		claims, err := utils.ParseMagicLinkToken(req.Token)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired link.")
+			return
+		}
+
+		sub, _ := claims.GetSubject()
+		userID, err := strconv.Atoi(sub)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired link.")
+			return
+		}
+
+		jti, _ := claims["jti"].(string)
+		email, _ := claims["email"].(string)
+		clientID, _ := claims["client_id"].(string)
+		nonce, _ := claims["nonce"].(string)
+
+		tokenRepo := tokenRepository.TokenRepository{}
+		ok, err := tokenRepo.ConsumeMagicLink(redis, jti)
+		if err != nil {
+			log.Printf("Error consuming magic link: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to log in.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired link.")
+			return
+		}
+
+		// A link sent before the address changed must not work any more.
+		userRepo := userRepository.UserRepository{}
+		user, err := userRepo.GetByID(db, userID)
+		if err != nil || user.Email != email {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired link.")
+			return
+		}
+
+		if !user.EmailVerified {
+			verified, err := userRepo.MarkEmailVerified(db, user.ID, user.Email)
+			if err != nil {
+				log.Printf("Error marking email of user %d verified: %v", user.ID, err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+				return
+			}
+			user.EmailVerified = verified
+		}
+
+		amr := []string{utils.AMREmail}
+
+		challenge, err := startMFAChallenge(db, redis, user, amr, clientID, nonce)
+		if err != nil {
+			log.Printf("Error starting MFA challenge for user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		if challenge.MFARequired {
+			utils.ResponseJSON(w, http.StatusOK, challenge)
+			return
+		}
+
+		respondWithLogin(w, db, user, clientID, nonce, amr)
+	}
+}
+
+// renderMagicLinkPage answers a GET of the link with a page to confirm the
+// login from. An invalid or expired token is reported straight away, but
+// nothing is consumed.
+func renderMagicLinkPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		renderAuthorizeError(w, http.StatusBadRequest, "The login link is incomplete.")
+		return
+	}
+
+	if _, err := utils.ParseMagicLinkToken(token); err != nil {
+		renderAuthorizeError(w, http.StatusUnauthorized, "The login link is invalid or has expired.")
+		return
+	}
+
+	setPageHeaders(w)
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+
+	if err := templates.ExecuteTemplate(w, "magic_link.html", magicLinkPage{Action: r.URL.Path, Token: token}); err != nil {
+		log.Printf("Error rendering magic link page: %v", err)
+	}
+}
+
+// isFormPost tells whether the request body is an HTML form.
+func isFormPost(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
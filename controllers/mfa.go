@@ -0,0 +1,490 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+	mfaRepository "github.com/jcprz/jwtapp/repository/mfa"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	webauthnRepository "github.com/jcprz/jwtapp/repository/webauthn"
+	"github.com/jcprz/jwtapp/utils"
+	"github.com/jcprz/jwtapp/webauthn"
+)
+
+// Second factors a user can complete an MFA challenge with.
+const (
+	mfaMethodTOTP         = "totp"
+	mfaMethodRecoveryCode = "recovery_code"
+	mfaMethodWebAuthn     = "webauthn"
+)
+
+// loginMFARequest completes an MFA challenge. Method is "totp",
+// "recovery_code" or "webauthn"; when left out it is guessed from what was
+// sent. Credential is the WebAuthn assertion, Code the code of the others.
+type loginMFARequest struct {
+	MFAToken   string                      `json:"mfa_token"`
+	Method     string                      `json:"method"`
+	Code       string                      `json:"code"`
+	Credential *webauthn.AssertionResponse `json:"credential"`
+}
+
+type totpRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// mfaMethods returns the second factors the user has set up.
+func mfaMethods(db *sql.DB, userID int) ([]string, error) {
+	mfaRepo := mfaRepository.MFARepository{}
+
+	methods := []string{}
+
+	hasTOTP, err := mfaRepo.HasTOTP(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if hasTOTP {
+		methods = append(methods, mfaMethodTOTP)
+
+		remaining, err := mfaRepo.CountRecoveryCodes(db, userID)
+		if err != nil {
+			return nil, err
+		}
+		if remaining > 0 {
+			methods = append(methods, mfaMethodRecoveryCode)
+		}
+	}
+
+	webauthnRepo := webauthnRepository.WebAuthnRepository{}
+	hasWebAuthn, err := webauthnRepo.HasCredentials(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if hasWebAuthn {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// startMFAChallenge hands out an MFA challenge when the user has a second
+// factor set up. Otherwise the returned challenge has MFARequired unset and
+// the login can go ahead.
+func startMFAChallenge(db *sql.DB, redis *redis.Client, user models.User, amr []string, clientID, nonce string) (models.MFAChallenge, error) {
+	methods, err := mfaMethods(db, user.ID)
+	if err != nil || len(methods) == 0 {
+		return models.MFAChallenge{}, err
+	}
+
+	token, jti, err := utils.GenerateMFAChallengeToken(user, amr, clientID, nonce)
+	if err != nil {
+		return models.MFAChallenge{}, err
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	if err := tokenRepo.SaveMFAChallenge(redis, jti, utils.MFAChallengeAttempts, utils.MFAChallengeTTL); err != nil {
+		return models.MFAChallenge{}, err
+	}
+
+	return models.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     methods,
+		ExpiresIn:   int64(utils.MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// completeMFAChallenge checks the second factor for an MFA challenge. It
+// returns the user, the challenge's claims and the methods used so far; on
+// failure the status and message to send back instead. Wrong codes count
+// towards the failed-login lockout like wrong passwords, which a new
+// challenge doesn't reset, and a locked account can't complete a challenge;
+// Retry-After is set on w then. The account's failures are cleared once the
+// challenge is completed.
+func completeMFAChallenge(w http.ResponseWriter, r *http.Request, db *sql.DB, redis *redis.Client, req loginMFARequest) (models.User, jwt.MapClaims, []string, int, string) {
+	claims, err := utils.ParseMFAChallengeToken(req.MFAToken)
+	if err != nil {
+		return models.User{}, nil, nil, http.StatusUnauthorized, "Invalid or expired MFA token."
+	}
+
+	sub, _ := claims.GetSubject()
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return models.User{}, nil, nil, http.StatusUnauthorized, "Invalid or expired MFA token."
+	}
+
+	jti, _ := claims["jti"].(string)
+
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.GetByID(db, userID)
+	if err != nil {
+		return models.User{}, nil, nil, http.StatusUnauthorized, "Invalid or expired MFA token."
+	}
+
+	if status, message, retryAfter := loginBlock(redis, r, user.Email); status != http.StatusOK {
+		setRetryAfter(w, retryAfter)
+		return models.User{}, nil, nil, status, message
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	ok, err := tokenRepo.UseMFAChallengeAttempt(redis, jti)
+	if err != nil {
+		log.Printf("Error checking MFA challenge: %v", err)
+		return models.User{}, nil, nil, http.StatusServiceUnavailable, "Unable to verify code."
+	}
+	if !ok {
+		return models.User{}, nil, nil, http.StatusUnauthorized, "Invalid or expired MFA token."
+	}
+
+	method, ok, err := verifySecondFactor(db, redis, userID, jti, req)
+	if err != nil {
+		log.Printf("Error verifying second factor of user %d: %v", userID, err)
+		return models.User{}, nil, nil, http.StatusInternalServerError, "Server Error."
+	}
+	if !ok {
+		recordLoginFailure(redis, r, user.Email)
+		return models.User{}, nil, nil, http.StatusUnauthorized, "Invalid code."
+	}
+
+	// Only one request gets to turn the challenge into a session.
+	ok, err = tokenRepo.CompleteMFAChallenge(redis, jti)
+	if err != nil {
+		log.Printf("Error completing MFA challenge: %v", err)
+		return models.User{}, nil, nil, http.StatusServiceUnavailable, "Unable to verify code."
+	}
+	if !ok {
+		return models.User{}, nil, nil, http.StatusUnauthorized, "Invalid or expired MFA token."
+	}
+
+	resetLoginFailures(redis, r, user.Email)
+
+	amr := append(utils.ClaimAMR(claims), method, utils.AMRMultiFactor)
+
+	return user, claims, amr, http.StatusOK, ""
+}
+
+// verifySecondFactor checks the second factor sent for the MFA challenge jti
+// and returns the amr value it adds. Recovery codes only count while TOTP is
+// enabled, they are a fallback for it.
+func verifySecondFactor(db *sql.DB, redis *redis.Client, userID int, jti string, req loginMFARequest) (string, bool, error) {
+	method := req.Method
+	if method == "" {
+		switch {
+		case req.Credential != nil:
+			method = mfaMethodWebAuthn
+		case strings.Trim(req.Code, "0123456789 ") == "":
+			method = mfaMethodTOTP
+		default:
+			method = mfaMethodRecoveryCode
+		}
+	}
+
+	switch method {
+	case mfaMethodTOTP:
+		ok, err := verifyTOTP(db, userID, req.Code)
+		return utils.AMROTP, ok, err
+	case mfaMethodRecoveryCode:
+		mfaRepo := mfaRepository.MFARepository{}
+
+		hasTOTP, err := mfaRepo.HasTOTP(db, userID)
+		if err != nil || !hasTOTP {
+			return "", false, err
+		}
+
+		ok, err := mfaRepo.UseRecoveryCode(db, userID, utils.HashRecoveryCode(req.Code))
+		if ok {
+			log.Printf("User %d logged in with a recovery code", userID)
+		}
+		return utils.AMROTP, ok, err
+	case mfaMethodWebAuthn:
+		if req.Credential == nil {
+			return "", false, nil
+		}
+
+		ok, err := verifyWebAuthnFactor(db, redis, userID, jti, *req.Credential)
+		return utils.AMRHardwareKey, ok, err
+	default:
+		return "", false, nil
+	}
+}
+
+// verifyTOTP checks a code against the user's confirmed authenticator. Each
+// code is accepted once.
+func verifyTOTP(db *sql.DB, userID int, code string) (bool, error) {
+	mfaRepo := mfaRepository.MFARepository{}
+
+	totp, err := mfaRepo.GetTOTP(db, userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !totp.Confirmed {
+		return false, nil
+	}
+
+	counter, ok := utils.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return mfaRepo.UseTOTPCounter(db, userID, counter)
+}
+
+// LoginMFA is the second step of a login that returned an MFA challenge: it
+// takes the challenge's mfa_token and a code or WebAuthn assertion, and
+// responds like Login does.
+// A challenge allows a few attempts and expires after five minutes. Wrong
+// codes also count as failed logins, so asking for new challenges doesn't
+// buy more guesses.
+func (c Controller) LoginMFA(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req loginMFARequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.MFAToken == "" || (req.Code == "" && req.Credential == nil) {
+			utils.RespondWithError(w, http.StatusBadRequest, "MFA token and a code or credential are required.")
+			return
+		}
+
+		user, claims, amr, status, message := completeMFAChallenge(w, r, db, redis, req)
+		if status != http.StatusOK {
+			utils.RespondWithError(w, status, message)
+			return
+		}
+
+		if allowed, _ := emailVerificationPolicy(user); !allowed {
+			utils.RespondWithError(w, http.StatusForbidden, "Email address has not been verified.")
+			return
+		}
+
+		clientID, _ := claims["client_id"].(string)
+		nonce, _ := claims["nonce"].(string)
+
+		respondWithLogin(w, db, user, clientID, nonce, amr)
+	}
+}
+
+// EnrollTOTP starts setting up an authenticator app for the logged in user,
+// who confirms with their password. It returns the secret and the
+// otpauth:// URI to show as a QR code; TOTP is only enabled once ConfirmTOTP
+// has seen a code from the app. Meant to be wrapped by
+// TokenVerifyMiddleware.
+func (c Controller) EnrollTOTP(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req totpRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user, _, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			log.Printf("Error generating TOTP secret: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		mfaRepo := mfaRepository.MFARepository{}
+		ok, err = mfaRepo.SaveTOTPSecret(db, user.ID, secret)
+		if err != nil {
+			log.Printf("Error storing TOTP secret of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusConflict, "TOTP is already enabled.")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		utils.ResponseJSON(w, http.StatusOK, models.TOTPEnrollment{
+			Secret: secret,
+			URI:    utils.TOTPURI(secret, user.Email),
+		})
+	}
+}
+
+// ConfirmTOTP finishes an enrollment with the user's password and a code
+// from the authenticator app, after which logins ask for a code. The
+// response carries a fresh set of recovery codes, which is the only time
+// they are shown. Meant to be wrapped by TokenVerifyMiddleware.
+func (c Controller) ConfirmTOTP(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req totpRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user, _, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		mfaRepo := mfaRepository.MFARepository{}
+		totp, err := mfaRepo.GetTOTP(db, user.ID)
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusBadRequest, "TOTP enrollment has not been started.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up TOTP of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if totp.Confirmed {
+			utils.RespondWithError(w, http.StatusConflict, "TOTP is already enabled.")
+			return
+		}
+
+		counter, ok := utils.ValidateTOTP(totp.Secret, req.Code, time.Now())
+		if ok {
+			ok, err = mfaRepo.UseTOTPCounter(db, user.ID, counter)
+		}
+		if err == nil && ok {
+			err = mfaRepo.ConfirmTOTP(db, user.ID)
+		}
+		if err != nil {
+			log.Printf("Error confirming TOTP of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid code.")
+			return
+		}
+
+		respondWithNewRecoveryCodes(w, db, user.ID)
+	}
+}
+
+// DisableTOTP removes the authenticator app of the logged in user, who
+// confirms with their password and a current code. Meant to be wrapped by
+// TokenVerifyMiddleware.
+func (c Controller) DisableTOTP(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req totpRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user, _, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		ok, err := verifyTOTP(db, user.ID, req.Code)
+		if err != nil {
+			log.Printf("Error verifying TOTP code of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid code.")
+			return
+		}
+
+		mfaRepo := mfaRepository.MFARepository{}
+		err = mfaRepo.DeleteRecoveryCodes(db, user.ID)
+		if err == nil {
+			err = mfaRepo.DeleteTOTP(db, user.ID)
+		}
+		if err != nil {
+			log.Printf("Error disabling TOTP of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, "TOTP disabled")
+	}
+}
+
+// respondWithNewRecoveryCodes replaces the user's recovery codes and sends
+// the new ones back.
+func respondWithNewRecoveryCodes(w http.ResponseWriter, db *sql.DB, userID int) {
+	codes, err := utils.GenerateRecoveryCodes(utils.RecoveryCodeCount)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+		return
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, utils.HashRecoveryCode(code))
+	}
+
+	mfaRepo := mfaRepository.MFARepository{}
+	if err := mfaRepo.ReplaceRecoveryCodes(db, userID, hashes); err != nil {
+		log.Printf("Error storing recovery codes of user %d: %v", userID, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.ResponseJSON(w, http.StatusOK, models.RecoveryCodes{Codes: codes, Remaining: len(codes)})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged in user,
+// who confirms with their password. The old codes stop working. Meant to be
+// wrapped by TokenVerifyMiddleware.
+func (c Controller) RegenerateRecoveryCodes(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req totpRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user, _, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		mfaRepo := mfaRepository.MFARepository{}
+		hasTOTP, err := mfaRepo.HasTOTP(db, user.ID)
+		if err != nil {
+			log.Printf("Error looking up TOTP of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !hasTOTP {
+			utils.RespondWithError(w, http.StatusBadRequest, "TOTP is not enabled.")
+			return
+		}
+
+		respondWithNewRecoveryCodes(w, db, user.ID)
+	}
+}
+
+// RecoveryCodesStatus reports how many unused recovery codes the logged in
+// user has left. Meant to be wrapped by TokenVerifyMiddleware.
+func (c Controller) RecoveryCodesStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := requestUserID(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		mfaRepo := mfaRepository.MFARepository{}
+		remaining, err := mfaRepo.CountRecoveryCodes(db, userID)
+		if err != nil {
+			log.Printf("Error counting recovery codes of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, models.RecoveryCodes{Remaining: remaining})
+	}
+}
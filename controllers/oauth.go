@@ -0,0 +1,508 @@
+package controllers
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/models"
+	clientRepository "github.com/jcprz/jwtapp/repository/client"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+	"github.com/jcprz/jwtapp/webauthn"
+)
+
+const authorizationCodeTTL = time.Minute
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// authorizeRequest holds the parameters of an authorization request as they
+// travel from the query string, through the login form and back.
+type authorizeRequest struct {
+	Action              string
+	Client              models.Client
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Email               string
+	MFAToken            string
+	Error               string
+
+	// Set for the second step of a login: MFACode asks for a TOTP or
+	// recovery code, WebAuthnOptions holds the JSON options for
+	// navigator.credentials.get() and ScriptNonce allows the script that
+	// calls it.
+	MFACode         bool
+	WebAuthnOptions string
+	ScriptNonce     string
+}
+
+// Authorize implements the authorization endpoint of the authorization code
+// grant. GET renders the login and consent page, POST checks the credentials
+// and redirects back to the client with a code. PKCE with S256 is mandatory.
+func (c Controller) Authorize(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			renderAuthorizeError(w, http.StatusBadRequest, "Malformed request.")
+			return
+		}
+
+		clientRepo := clientRepository.ClientRepository{}
+		client, err := clientRepo.GetByClientID(db, r.Form.Get("client_id"))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error looking up OAuth client: %v", err)
+			}
+			renderAuthorizeError(w, http.StatusBadRequest, "Unknown client.")
+			return
+		}
+
+		// Until the redirect URI is known to be registered we must not send
+		// the user anywhere, errors are shown on our own page.
+		redirectURI := r.Form.Get("redirect_uri")
+		if !isRegisteredRedirectURI(client, redirectURI) {
+			renderAuthorizeError(w, http.StatusBadRequest, "Invalid redirect URI.")
+			return
+		}
+
+		req := authorizeRequest{
+			Action:              r.URL.Path,
+			Client:              client,
+			RedirectURI:         redirectURI,
+			Scope:               r.Form.Get("scope"),
+			State:               r.Form.Get("state"),
+			Nonce:               r.Form.Get("nonce"),
+			CodeChallenge:       r.Form.Get("code_challenge"),
+			CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		}
+
+		if r.Form.Get("response_type") != "code" {
+			redirectWithParams(w, r, redirectURI, url.Values{"error": {"unsupported_response_type"}, "state": {req.State}})
+			return
+		}
+
+		if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+			redirectWithParams(w, r, redirectURI, url.Values{
+				"error":             {"invalid_request"},
+				"error_description": {"PKCE with code_challenge_method=S256 is required."},
+				"state":             {req.State},
+			})
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			renderAuthorize(w, http.StatusOK, req)
+			return
+		}
+
+		if r.Form.Get("consent") != "allow" {
+			redirectWithParams(w, r, redirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}})
+			return
+		}
+
+		user, amr, ok := authenticateAuthorizeForm(w, r, db, redis, req)
+		if !ok {
+			return
+		}
+
+		code, err := utils.GenerateRandomToken(32)
+		if err != nil {
+			log.Printf("Error generating authorization code: %v", err)
+			renderAuthorizeError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		tokenRepo := tokenRepository.TokenRepository{}
+		err = tokenRepo.SaveAuthorizationCode(redis, utils.HashToken(code), models.AuthorizationCode{
+			ClientID:            client.ClientID,
+			RedirectURI:         redirectURI,
+			UserID:              user.ID,
+			Scope:               req.Scope,
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			AuthTime:            time.Now().Unix(),
+			AMR:                 amr,
+		}, authorizationCodeTTL)
+		if err != nil {
+			log.Printf("Error storing authorization code: %v", err)
+			renderAuthorizeError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		redirectWithParams(w, r, redirectURI, url.Values{"code": {code}, "state": {req.State}})
+	}
+}
+
+// authenticateAuthorizeForm signs the user in through the authorize page:
+// email and password first, then, when the user has a second factor, a code
+// along with the MFA challenge the first step handed out. Until that is done
+// it renders the page again and returns false.
+func authenticateAuthorizeForm(w http.ResponseWriter, r *http.Request, db *sql.DB, redis *redis.Client, req authorizeRequest) (models.User, []string, bool) {
+	if mfaToken := r.Form.Get("mfa_token"); mfaToken != "" {
+		mfaReq := loginMFARequest{MFAToken: mfaToken, Code: r.Form.Get("code")}
+		if credential := r.Form.Get("webauthn_credential"); credential != "" {
+			mfaReq.Credential = &webauthn.AssertionResponse{}
+			if err := json.Unmarshal([]byte(credential), mfaReq.Credential); err != nil {
+				mfaReq.Credential = nil
+			}
+		}
+
+		user, claims, amr, status, message := completeMFAChallenge(w, r, db, redis, mfaReq)
+		if status == http.StatusOK && claims["client_id"] != req.Client.ClientID {
+			status, message = http.StatusUnauthorized, "Invalid or expired MFA token."
+		}
+		if status != http.StatusOK {
+			// A mistyped code can be retried, anything else starts over.
+			if message == "Invalid code." {
+				var err error
+				if req, err = withMFAStep(db, redis, req, mfaToken); err != nil {
+					log.Printf("Error preparing MFA step: %v", err)
+					renderAuthorizeError(w, http.StatusInternalServerError, "Server Error.")
+					return models.User{}, nil, false
+				}
+			}
+			req.Error = message
+			renderAuthorize(w, status, req)
+			return models.User{}, nil, false
+		}
+
+		return user, amr, true
+	}
+
+	req.Email = r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	if status, message, retryAfter := loginBlock(redis, r, req.Email); status != http.StatusOK {
+		setRetryAfter(w, retryAfter)
+		req.Error = message
+		renderAuthorize(w, status, req)
+		return models.User{}, nil, false
+	}
+
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.Login(db, redis, models.User{Email: req.Email})
+	if err != nil || password == "" || !verifyLoginPassword(db, user, password) {
+		recordLoginFailure(redis, r, req.Email)
+		req.Error = "Invalid credentials."
+		renderAuthorize(w, http.StatusUnauthorized, req)
+		return models.User{}, nil, false
+	}
+
+	if allowed, _ := emailVerificationPolicy(user); !allowed {
+		req.Error = "Please verify your email address first."
+		renderAuthorize(w, http.StatusForbidden, req)
+		return models.User{}, nil, false
+	}
+
+	amr := []string{utils.AMRPassword}
+
+	challenge, err := startMFAChallenge(db, redis, user, amr, req.Client.ClientID, "")
+	if err != nil {
+		log.Printf("Error starting MFA challenge for user %d: %v", user.ID, err)
+		renderAuthorizeError(w, http.StatusInternalServerError, "Server Error.")
+		return models.User{}, nil, false
+	}
+
+	if challenge.MFARequired {
+		if req, err = withMFAStep(db, redis, req, challenge.MFAToken); err != nil {
+			log.Printf("Error preparing MFA step: %v", err)
+			renderAuthorizeError(w, http.StatusInternalServerError, "Server Error.")
+			return models.User{}, nil, false
+		}
+		renderAuthorize(w, http.StatusOK, req)
+		return models.User{}, nil, false
+	}
+
+	resetLoginFailures(redis, r, req.Email)
+
+	return user, amr, true
+}
+
+// withMFAStep sets the page up for the second step of a login, offering the
+// second factors the user has.
+func withMFAStep(db *sql.DB, redis *redis.Client, req authorizeRequest, mfaToken string) (authorizeRequest, error) {
+	req.MFAToken = mfaToken
+
+	claims, err := utils.ParseMFAChallengeToken(mfaToken)
+	if err != nil {
+		return req, err
+	}
+
+	sub, _ := claims.GetSubject()
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return req, err
+	}
+
+	methods, err := mfaMethods(db, userID)
+	if err != nil {
+		return req, err
+	}
+
+	for _, method := range methods {
+		switch method {
+		case mfaMethodTOTP:
+			req.MFACode = true
+		case mfaMethodWebAuthn:
+			options, ok, err := webAuthnMFAOptions(db, redis, mfaToken)
+			if err != nil || !ok {
+				return req, err
+			}
+
+			data, err := json.Marshal(options)
+			if err != nil {
+				return req, err
+			}
+			req.WebAuthnOptions = string(data)
+
+			if req.ScriptNonce, err = utils.GenerateRandomToken(16); err != nil {
+				return req, err
+			}
+		}
+	}
+
+	return req, nil
+}
+
+func isRegisteredRedirectURI(client models.Client, redirectURI string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, _ := url.Parse(redirectURI)
+
+	query := target.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+}
+
+func renderAuthorize(w http.ResponseWriter, status int, req authorizeRequest) {
+	setPageHeaders(w)
+	if req.ScriptNonce != "" {
+		w.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'none'; script-src 'nonce-%s'; style-src 'unsafe-inline'; frame-ancestors 'none'", req.ScriptNonce))
+	}
+	w.WriteHeader(status)
+
+	if err := templates.ExecuteTemplate(w, "authorize.html", req); err != nil {
+		log.Printf("Error rendering authorize page: %v", err)
+	}
+}
+
+func renderAuthorizeError(w http.ResponseWriter, status int, message string) {
+	setPageHeaders(w)
+	w.WriteHeader(status)
+
+	if err := templates.ExecuteTemplate(w, "error.html", message); err != nil {
+		log.Printf("Error rendering error page: %v", err)
+	}
+}
+
+// Token implements the token endpoint for the authorization_code,
+// refresh_token and client_credentials grants. Confidential clients
+// authenticate with their secret for every grant.
+func (c Controller) Token(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed request.")
+			return
+		}
+
+		grantType := r.PostForm.Get("grant_type")
+		if grantType == "" {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is missing.")
+			return
+		}
+
+		client, authenticated, err := authenticateClient(db, r)
+		if err != nil {
+			respondWithClientError(w, r, err)
+			return
+		}
+
+		switch grantType {
+		case "authorization_code":
+			authorizationCodeGrant(w, r, db, redis, client)
+		case "refresh_token":
+			refreshTokenGrant(w, r, db, client)
+		case "client_credentials":
+			if !authenticated {
+				respondWithClientError(w, r, errInvalidClient)
+				return
+			}
+			clientCredentialsGrant(w, r, client)
+		default:
+			respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		}
+	}
+}
+
+func authorizationCodeGrant(w http.ResponseWriter, r *http.Request, db *sql.DB, rds *redis.Client, client models.Client) {
+	code := r.PostForm.Get("code")
+	clientID := client.ClientID
+	if code == "" || clientID == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "code and client_id are required.")
+		return
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	stored, err := tokenRepo.ConsumeAuthorizationCode(rds, utils.HashToken(code))
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Error redeeming authorization code: %v", err)
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code.")
+		return
+	}
+
+	if stored.ClientID != clientID || stored.RedirectURI != r.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code.")
+		return
+	}
+
+	if !utils.VerifyPKCE(r.PostForm.Get("code_verifier"), stored.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code verifier.")
+		return
+	}
+
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.GetByID(db, stored.UserID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code.")
+		return
+	}
+
+	var jwt models.JWT
+	if _, limited := emailVerificationPolicy(user); limited {
+		jwt, err = issueAccessToken(db, user, clientID, stored.AMR)
+	} else {
+		jwt, err = issueTokens(db, user, "", clientID, stored.AMR)
+	}
+	if err == nil && hasScope(stored.Scope, "openid") {
+		jwt.IDToken, err = utils.GenerateIDToken(user, clientID, stored.Nonce, time.Unix(stored.AuthTime, 0), stored.AMR)
+	}
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	respondWithTokens(w, jwt, stored.Scope)
+}
+
+func refreshTokenGrant(w http.ResponseWriter, r *http.Request, db *sql.DB, client models.Client) {
+	refreshToken := r.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required.")
+		return
+	}
+
+	user, stored, status, message := rotateRefreshToken(db, refreshToken, client.ClientID)
+	if status == http.StatusInternalServerError {
+		respondWithOAuthError(w, status, "server_error", "")
+		return
+	}
+	if status != http.StatusOK {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", message)
+		return
+	}
+
+	jwt, err := issueTokens(db, user, stored.FamilyID, stored.ClientID, stored.AMR)
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	respondWithTokens(w, jwt, "")
+}
+
+// clientCredentialsGrant issues an access token to the client itself. There is
+// no refresh token, the client can simply ask again.
+func clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client models.Client) {
+	scope, ok := grantScope(client, r.PostForm.Get("scope"))
+	if !ok {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "The client may not request this scope.")
+		return
+	}
+
+	token, err := utils.GenerateClientToken(client.ClientID, scope)
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	respondWithTokens(w, models.JWT{
+		Token:     token,
+		ExpiresIn: int64(utils.AccessTokenTTL().Seconds()),
+	}, scope)
+}
+
+func respondWithTokens(w http.ResponseWriter, jwt models.JWT, scope string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	utils.ResponseJSON(w, http.StatusOK, models.TokenResponse{
+		AccessToken:  jwt.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    jwt.ExpiresIn,
+		RefreshToken: jwt.RefreshToken,
+		IDToken:      jwt.IDToken,
+		Scope:        scope,
+	})
+}
+
+func respondWithOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	utils.ResponseJSON(w, status, models.OAuthError{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+func hasScope(scope, wanted string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == wanted {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jcprz/jwtapp/models"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// baseURL is where clients reach us: APP_BASE_URL when it is set, then the
+// issuer when it is a URL, otherwise whatever host the request came in on.
+// The last is up to the client, so links sent by mail use appBaseURL instead.
+func baseURL(r *http.Request) string {
+	if base, err := utils.AppBaseURL(); err == nil {
+		return base
+	}
+
+	issuer := utils.Issuer()
+	if strings.HasPrefix(issuer, "https://") || strings.HasPrefix(issuer, "http://") {
+		return strings.TrimRight(issuer, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// appBaseURL returns APP_BASE_URL for building links sent by mail. Handlers
+// that send links call it when they are set up, so a missing or invalid
+// APP_BASE_URL stops the app at startup.
+func appBaseURL() string {
+	base, err := utils.AppBaseURL()
+	if err != nil {
+		log.Fatalf("Cannot build links for emails: %v", err)
+	}
+
+	return base
+}
+
+// OpenIDConfiguration serves the discovery document at
+// /.well-known/openid-configuration.
+func (c Controller) OpenIDConfiguration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ring, err := utils.LoadKeyRing()
+		if err != nil {
+			log.Printf("Error loading signing keys: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		key, err := ring.SigningKey(time.Now())
+		if err != nil {
+			log.Printf("Error loading signing keys: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		base := baseURL(r)
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		utils.ResponseJSON(w, http.StatusOK, models.OpenIDConfiguration{
+			Issuer:                           utils.Issuer(),
+			AuthorizationEndpoint:            base + "/authorize",
+			TokenEndpoint:                    base + "/token",
+			UserinfoEndpoint:                 base + "/userinfo",
+			IntrospectionEndpoint:            base + "/introspect",
+			RevocationEndpoint:               base + "/revoke",
+			JwksURI:                          base + "/.well-known/jwks.json",
+			ResponseTypesSupported:           []string{"code"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: []string{key.Method.Alg()},
+			ScopesSupported:                  []string{"openid", "email"},
+			ClaimsSupported:                  []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "amr"},
+			GrantTypesSupported:              []string{"authorization_code", "refresh_token", "client_credentials"},
+			CodeChallengeMethodsSupported:    []string{"S256"},
+			TokenEndpointAuthMethods:         []string{"none", "client_secret_basic", "client_secret_post"},
+		})
+	}
+}
+
+// UserInfo returns the claims of the user the bearer token belongs to. Meant
+// to be wrapped by TokenVerifyMiddleware.
+func (c Controller) UserInfo(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := requestPrincipal(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		userID, err := principal.UserID()
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		userRepo := userRepository.UserRepository{}
+		user, err := userRepo.GetByID(db, userID)
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, models.UserInfo{
+			Sub:           principal.Subject,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+		})
+	}
+}
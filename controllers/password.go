@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/mailer"
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword mails a password reset link. The response doesn't depend on
+// whether the address has an account, and the lookup and mailing happen after
+// responding so the timing doesn't give it away either. The link points at
+// APP_BASE_URL, which has to be set.
+func (c Controller) ForgotPassword(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	resetURL := appBaseURL() + "/password/reset"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+
+		json.NewDecoder(r.Body).Decode(&user)
+
+		if user.Email == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Email is missing.")
+			return
+		}
+
+		go sendPasswordResetEmail(db, redis, mail, user.Email, resetURL)
+
+		utils.ResponseJSON(w, http.StatusAccepted, "If the address belongs to an account, a password reset email is on its way")
+	}
+}
+
+func sendPasswordResetEmail(db *sql.DB, redis *redis.Client, mail mailer.Mailer, email, resetURL string) {
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.Login(db, redis, models.User{Email: email})
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up user for password reset: %v", err)
+		}
+		return
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		log.Printf("Error generating password reset token: %v", err)
+		return
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	if err := tokenRepo.CreatePasswordResetToken(db, user.ID, utils.HashToken(token), time.Now().Add(utils.PasswordResetTTL())); err != nil {
+		log.Printf("Error storing password reset token: %v", err)
+		return
+	}
+
+	msg, err := mailer.Render(user.Email, "reset_password", map[string]string{
+		"Link":     resetURL + "?token=" + url.QueryEscape(token),
+		"ValidFor": validFor(utils.PasswordResetTTL()),
+	})
+	if err == nil {
+		err = mail.Send(msg)
+	}
+	if err != nil {
+		log.Printf("Error sending password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. Every
+// session of the user is revoked afterwards, along with any other reset
+// tokens still out there, and the user is notified by mail.
+func (c Controller) ResetPassword(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req resetPasswordRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Token == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Token is missing.")
+			return
+		}
+
+		if req.Password == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Password is missing.")
+			return
+		}
+
+		tokenRepo := tokenRepository.TokenRepository{}
+		userRepo := userRepository.UserRepository{}
+		tokenHash := utils.HashToken(req.Token)
+
+		// Check the password before using up the token, so a refused
+		// password can be corrected without asking for another link.
+		userID, err := tokenRepo.PasswordResetTokenUser(db, tokenHash)
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up password reset token: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		user, err := userRepo.GetByID(db, userID)
+		if err != nil {
+			log.Printf("Error looking up user %d for password reset: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		if !validNewPassword(w, "password", req.Password, user.Email) {
+			return
+		}
+
+		userID, err = tokenRepo.ConsumePasswordResetToken(db, tokenHash)
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error redeeming password reset token: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		hash, err := utils.HashPassword(req.Password)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		if err := userRepo.UpdatePassword(db, userID, hash); err != nil {
+			log.Printf("Error updating password of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		if err := tokenRepo.DeletePasswordResetTokens(db, userID); err != nil {
+			log.Printf("Error deleting password reset tokens of user %d: %v", userID, err)
+		}
+
+		if err := revokeUserSessions(db, redis, userID); err != nil {
+			log.Printf("Error revoking sessions of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		sendNotification(mail, user.Email, "password_changed", nil)
+
+		utils.ResponseJSON(w, http.StatusOK, "Password has been reset")
+	}
+}
+
+// validNewPassword checks a new password against the password policy. When
+// it fails the response has been written: 400 listing the broken rules as
+// errors of field.
+func validNewPassword(w http.ResponseWriter, field, password, email string) bool {
+	errs, err := utils.CheckPassword(field, password, email)
+	if err != nil {
+		log.Printf("Error checking password: %v", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+		return false
+	}
+
+	if len(errs) > 0 {
+		utils.RespondWithFieldErrors(w, http.StatusBadRequest, "Password doesn't meet the password policy.", errs)
+		return false
+	}
+
+	return true
+}
+
+// verifyLoginPassword checks the password of a login against the user's
+// stored hash. A hash in an older format, or made with other parameters
+// than the configured ones, is replaced while the password is at hand.
+func verifyLoginPassword(db *sql.DB, user models.User, password string) bool {
+	ok, rehash := utils.VerifyPassword(user.Password, password)
+	if !ok || !rehash {
+		return ok
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("Error rehashing password of user %d: %v", user.ID, err)
+		return true
+	}
+
+	userRepo := userRepository.UserRepository{}
+	if _, err := userRepo.RehashPassword(db, user.ID, user.Password, hash); err != nil {
+		log.Printf("Error storing rehashed password of user %d: %v", user.ID, err)
+	}
+
+	return true
+}
@@ -8,8 +8,16 @@ import (
 
 type Controller struct{}
 
+// ProtectedEndpoint tells the caller who their access token says they are.
+// Meant to be wrapped by TokenVerifyMiddleware.
 func (c Controller) ProtectedEndpoint() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		utils.ResponseJSON(w, http.StatusOK, "Yes")
+		principal, err := requestPrincipal(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, principal)
 	}
 }
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	ratelimitRepository "github.com/jcprz/jwtapp/repository/ratelimit"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// RateLimitKey picks what a rate limit counts requests against.
+type RateLimitKey func(r *http.Request) string
+
+// RateLimitByIP counts requests per client IP.
+func RateLimitByIP(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+// RateLimitBySubject counts requests per user, by the sub of a valid access
+// token, and per client IP for requests without one. Behind
+// TokenVerifyMiddleware it uses the principal in the request context;
+// otherwise it checks the token's signature, but not whether it was revoked.
+func RateLimitBySubject(r *http.Request) string {
+	if principal, ok := utils.PrincipalFromContext(r.Context()); ok {
+		return "sub:" + principal.Subject
+	}
+
+	claims, err := utils.ParseToken(bearerToken(r))
+	if err != nil {
+		return RateLimitByIP(r)
+	}
+
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return RateLimitByIP(r)
+	}
+
+	return "sub:" + sub
+}
+
+// RateLimitByClient counts requests per OAuth client ID, from Basic auth or
+// the client_id parameter, and per client IP for requests without one. The
+// ID is taken before the client authenticates, so this is for sharing a
+// budget between a client's requests, not for telling clients apart safely.
+func RateLimitByClient(r *http.Request) string {
+	r.ParseForm()
+
+	clientID, _, _, err := clientCredentials(r)
+	if err != nil || clientID == "" {
+		clientID = r.Form.Get("client_id")
+	}
+	if clientID == "" {
+		return RateLimitByIP(r)
+	}
+
+	return "client:" + clientID
+}
+
+// RateLimitMiddleware allows limit requests per key in any sliding window,
+// answering 429 with Retry-After once it is used up. Responses carry the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers. name keeps the counts of different limits apart. A zero limit
+// turns it off. It can be attached to a whole router with router.Use or to a
+// single route by wrapping its handler.
+//
+// Unlike the login lockout, requests go through when Redis is down: a rate
+// limit on every route must not take the whole API down with it.
+func (c Controller) RateLimitMiddleware(redis *redis.Client, name string, limit utils.RateLimit, key RateLimitKey) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if limit.Limit <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rateLimitRepo := ratelimitRepository.RateLimitRepository{}
+
+			window, err := rateLimitRepo.Hit(redis, name+":"+key(r), limit.Limit, limit.Window, time.Now())
+			if err != nil {
+				log.Printf("Error checking rate limit %s: %v", name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setRateLimitHeaders(w, limit, window)
+
+			if !window.Allowed {
+				setRetryAfter(w, limit.RetryAfter(window.Previous, window.Current, window.Elapsed))
+				utils.RespondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later.")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setRateLimitHeaders describes the limit as the IETF RateLimit header fields
+// draft does. Reset is when the current fixed window ends, after which the
+// sliding window starts freeing up requests.
+func setRateLimitHeaders(w http.ResponseWriter, limit utils.RateLimit, window ratelimitRepository.Window) {
+	remaining := limit.Remaining(window.Previous, window.Current, window.Elapsed)
+	reset := int((limit.Window - window.Elapsed + time.Second - 1) / time.Second)
+
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(limit.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+	w.Header().Set("RateLimit-Policy", strconv.FormatInt(limit.Limit, 10)+";w="+strconv.Itoa(int(limit.Window/time.Second)))
+}
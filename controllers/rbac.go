@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/jcprz/jwtapp/models"
+	roleRepository "github.com/jcprz/jwtapp/repository/role"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+type roleRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// withRoles loads the user's roles and the permissions they grant, which
+// access tokens carry in the roles and scope claims. Tokens issued to the
+// OAuth client clientID on the user's behalf get the roles but not the
+// permissions: a third party app doesn't inherit what an admin may do.
+func withRoles(db *sql.DB, user models.User, clientID string) (models.User, error) {
+	roleRepo := roleRepository.RoleRepository{}
+
+	roles, err := roleRepo.UserRoles(db, user.ID)
+	if err != nil {
+		return user, err
+	}
+
+	user.Roles, user.Permissions = roles, nil
+	if clientID != "" {
+		return user, nil
+	}
+
+	user.Permissions, err = roleRepo.UserPermissions(db, user.ID)
+	return user, err
+}
+
+// RequireScope lets a request through only with a valid access token whose
+// scope claim includes every one of scopes. It does what
+// TokenVerifyMiddleware does, the principal in the request context included,
+// so a route needs only one of the two. A token
+// without the scopes gets 403 and an RFC 6750 insufficient_scope challenge.
+func (c Controller) RequireScope(redis *redis.Client, scopes ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr := bearerToken(r)
+			if tokenStr == "" {
+				utils.RespondWithError(w, http.StatusUnauthorized, "Missing or invalid Authorization header")
+				return
+			}
+
+			claims, status, message := verifyToken(redis, tokenStr)
+			if status != http.StatusOK {
+				utils.RespondWithError(w, status, message)
+				return
+			}
+
+			if !utils.HasScopes(claims, scopes...) {
+				respondWithInsufficientScope(w, scopes)
+				return
+			}
+
+			ctx := utils.ContextWithPrincipal(r.Context(), utils.NewPrincipal(claims))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func respondWithInsufficientScope(w http.ResponseWriter, scopes []string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+	utils.RespondWithError(w, http.StatusForbidden, "Insufficient scope.")
+}
+
+// requireScopes checks that the caller, as put in the request context by
+// RequireScope or TokenVerifyMiddleware, was granted every one of scopes.
+// Handlers that must never run without a permission call it themselves, so
+// a route registered without RequireScope fails closed. On failure the
+// response has been written.
+func requireScopes(w http.ResponseWriter, r *http.Request, scopes ...string) bool {
+	principal, err := requestPrincipal(r)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Missing or invalid Authorization header")
+		return false
+	}
+
+	if !principal.HasScopes(scopes...) {
+		respondWithInsufficientScope(w, scopes)
+		return false
+	}
+
+	return true
+}
+
+// roleTarget decodes a role request and looks up the user and role it
+// names. On failure the response has been written.
+func roleTarget(w http.ResponseWriter, r *http.Request, db *sql.DB, redis *redis.Client) (models.User, string, bool) {
+	var req roleRequest
+
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if req.Email == "" || req.Role == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Email and role are required.")
+		return models.User{}, "", false
+	}
+
+	roleRepo := roleRepository.RoleRepository{}
+	exists, err := roleRepo.RoleExists(db, req.Role)
+	if err != nil {
+		log.Printf("Error looking up role %s: %v", req.Role, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+		return models.User{}, "", false
+	}
+	if !exists {
+		utils.RespondWithError(w, http.StatusNotFound, "Role not found.")
+		return models.User{}, "", false
+	}
+
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.Login(db, redis, models.User{Email: req.Email})
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(w, http.StatusNotFound, "User not found")
+		return models.User{}, "", false
+	}
+	if err != nil {
+		log.Printf("Error looking up user %s: %v", req.Email, err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+		return models.User{}, "", false
+	}
+
+	return user, req.Role, true
+}
+
+// AssignRole gives the user with {"email": "..."} the {"role": "..."}. The
+// new permissions show up in the user's tokens from the next login or
+// refresh. Needs the roles:assign permission, so it has to be wrapped by
+// RequireScope(redis, "roles:assign").
+func (c Controller) AssignRole(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireScopes(w, r, "roles:assign") {
+			return
+		}
+
+		user, role, ok := roleTarget(w, r, db, redis)
+		if !ok {
+			return
+		}
+
+		roleRepo := roleRepository.RoleRepository{}
+		if _, err := roleRepo.Assign(db, user.ID, role); err != nil {
+			log.Printf("Error assigning role %s to user %d: %v", role, user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		log.Printf("Role %s assigned to user %d", role, user.ID)
+		utils.ResponseJSON(w, http.StatusOK, "Role has been assigned")
+	}
+}
+
+// RevokeRole takes a role away, with the same body as AssignRole. Tokens
+// already issued would keep the role's permissions until they expire, so
+// every session of the user is revoked too. Needs the roles:assign
+// permission, so it has to be wrapped by RequireScope(redis, "roles:assign").
+func (c Controller) RevokeRole(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireScopes(w, r, "roles:assign") {
+			return
+		}
+
+		user, role, ok := roleTarget(w, r, db, redis)
+		if !ok {
+			return
+		}
+
+		roleRepo := roleRepository.RoleRepository{}
+		revoked, err := roleRepo.Revoke(db, user.ID, role)
+		if err != nil {
+			log.Printf("Error revoking role %s of user %d: %v", role, user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !revoked {
+			utils.RespondWithError(w, http.StatusNotFound, "User doesn't have this role.")
+			return
+		}
+
+		if err := revokeUserSessions(db, redis, user.ID); err != nil {
+			log.Printf("Error revoking sessions of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		log.Printf("Role %s revoked from user %d", role, user.ID)
+		utils.ResponseJSON(w, http.StatusOK, "Role has been revoked")
+	}
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// Revoke implements token revocation (RFC 7009). Clients can only revoke
+// tokens that were issued to them: confidential clients authenticate with
+// their secret, public clients name themselves with client_id, and tokens
+// from /login belong to callers that send no client at all. Revoking a
+// refresh token ends its whole session. Unknown tokens are not an error, the
+// response is the same empty 200 either way.
+func (c Controller) Revoke(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed request.")
+			return
+		}
+
+		client, _, err := authenticateClient(db, r)
+		if err != nil {
+			respondWithClientError(w, r, err)
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required.")
+			return
+		}
+
+		// The hint only decides which kind of token is tried first.
+		revokers := []func() (bool, int, string){
+			func() (bool, int, string) { return revokeAccessToken(redis, client, token) },
+			func() (bool, int, string) { return revokeRefreshToken(db, client, token) },
+		}
+		if r.PostForm.Get("token_type_hint") == "refresh_token" {
+			revokers[0], revokers[1] = revokers[1], revokers[0]
+		}
+
+		for _, revoke := range revokers {
+			found, status, code := revoke()
+			if status != http.StatusOK {
+				respondWithOAuthError(w, status, code, "")
+				return
+			}
+			if found {
+				break
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// revokeAccessToken denylists token if it is one of our access tokens. It
+// reports whether the token was recognised and, when revocation was refused
+// or failed, the status and OAuth error code to send back.
+func revokeAccessToken(redis *redis.Client, client models.Client, token string) (bool, int, string) {
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		return false, http.StatusOK, ""
+	}
+
+	if owner, _ := claims["client_id"].(string); owner != client.ClientID {
+		return true, http.StatusBadRequest, "unauthorized_client"
+	}
+
+	if err := denyAccessToken(redis, claims); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		return true, http.StatusServiceUnavailable, "temporarily_unavailable"
+	}
+
+	return true, http.StatusOK, ""
+}
+
+func revokeRefreshToken(db *sql.DB, client models.Client, token string) (bool, int, string) {
+	tokenRepo := tokenRepository.TokenRepository{}
+
+	stored, err := tokenRepo.FindRefreshToken(db, utils.HashToken(token))
+	if err == sql.ErrNoRows {
+		return false, http.StatusOK, ""
+	}
+	if err != nil {
+		log.Printf("Error looking up refresh token: %v", err)
+		return false, http.StatusServiceUnavailable, "temporarily_unavailable"
+	}
+
+	if stored.ClientID != client.ClientID {
+		return true, http.StatusBadRequest, "unauthorized_client"
+	}
+
+	if err := tokenRepo.RevokeTokenFamily(db, stored.FamilyID); err != nil {
+		log.Printf("Error revoking refresh token family: %v", err)
+		return true, http.StatusServiceUnavailable, "temporarily_unavailable"
+	}
+
+	return true, http.StatusOK, ""
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Every refresh token can be used exactly once: presenting one that has
+// already been rotated revokes the whole family it belongs to. Only first-party
+// refresh tokens are accepted; those of OAuth clients go to the token
+// endpoint, where the client authenticates.
+func (c Controller) Refresh(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req refreshRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.RefreshToken == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Refresh token is missing.")
+			return
+		}
+
+		user, stored, status, message := rotateRefreshToken(db, req.RefreshToken, "")
+		if status != http.StatusOK {
+			utils.RespondWithError(w, status, message)
+			return
+		}
+
+		jwt, err := issueTokens(db, user, stored.FamilyID, stored.ClientID, stored.AMR)
+		if err != nil {
+			log.Printf("Error generating token: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token.")
+			return
+		}
+
+		w.Header().Set("Authorization", jwt.Token)
+		utils.ResponseJSON(w, http.StatusOK, jwt)
+	}
+}
+
+// rotateRefreshToken consumes a refresh token and returns the user and the
+// stored token a replacement should be issued for. clientID is the OAuth
+// client presenting the token, empty for first-party refreshes; a token
+// issued to anyone else is refused before it is rotated, so another client
+// can't burn the token family. Anything other than http.StatusOK comes with
+// a message suitable for the client.
+func rotateRefreshToken(db *sql.DB, refreshToken, clientID string) (models.User, models.RefreshToken, int, string) {
+	tokenRepo := tokenRepository.TokenRepository{}
+
+	stored, err := tokenRepo.FindRefreshToken(db, utils.HashToken(refreshToken))
+	if err == sql.ErrNoRows {
+		return models.User{}, models.RefreshToken{}, http.StatusUnauthorized, "Invalid refresh token."
+	}
+	if err != nil {
+		log.Printf("Error looking up refresh token: %v", err)
+		return models.User{}, models.RefreshToken{}, http.StatusInternalServerError, "Server Error."
+	}
+
+	if stored.ClientID != clientID || stored.Revoked {
+		return models.User{}, models.RefreshToken{}, http.StatusUnauthorized, "Invalid refresh token."
+	}
+
+	if stored.Used {
+		log.Printf("Refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+		if err := tokenRepo.RevokeTokenFamily(db, stored.FamilyID); err != nil {
+			log.Printf("Error revoking refresh token family: %v", err)
+		}
+		return models.User{}, models.RefreshToken{}, http.StatusUnauthorized, "Invalid refresh token."
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return models.User{}, models.RefreshToken{}, http.StatusUnauthorized, "Refresh token has expired."
+	}
+
+	rotated, err := tokenRepo.MarkRefreshTokenUsed(db, stored.ID)
+	if err != nil {
+		log.Printf("Error rotating refresh token: %v", err)
+		return models.User{}, models.RefreshToken{}, http.StatusInternalServerError, "Server Error."
+	}
+
+	if !rotated {
+		// Someone else rotated this token between our read and write.
+		log.Printf("Concurrent refresh token use for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+		if err := tokenRepo.RevokeTokenFamily(db, stored.FamilyID); err != nil {
+			log.Printf("Error revoking refresh token family: %v", err)
+		}
+		return models.User{}, models.RefreshToken{}, http.StatusUnauthorized, "Invalid refresh token."
+	}
+
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.GetByID(db, stored.UserID)
+	if err != nil {
+		return models.User{}, models.RefreshToken{}, http.StatusUnauthorized, "Invalid refresh token."
+	}
+
+	return user, stored, http.StatusOK, ""
+}
+
+// issueTokens creates an access token and a refresh token for user. An empty
+// familyID starts a new refresh token family, i.e. a new session. clientID is
+// the OAuth client the session belongs to, if any, and amr how the user
+// authenticated when the session started.
+func issueTokens(db *sql.DB, user models.User, familyID, clientID string, amr []string) (models.JWT, error) {
+	var jwt models.JWT
+
+	user, err := withRoles(db, user, clientID)
+	if err != nil {
+		return jwt, err
+	}
+
+	token, err := utils.GenerateTokenForClient(user, clientID, amr)
+	if err != nil {
+		return jwt, err
+	}
+
+	if familyID == "" {
+		familyID, err = utils.GenerateRandomToken(16)
+		if err != nil {
+			return jwt, err
+		}
+	}
+
+	refreshToken, refreshHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return jwt, err
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	_, err = tokenRepo.CreateRefreshToken(db, models.RefreshToken{
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		ClientID:  clientID,
+		TokenHash: refreshHash,
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL()),
+		AMR:       amr,
+	})
+	if err != nil {
+		return jwt, err
+	}
+
+	jwt.Token = token
+	jwt.RefreshToken = refreshToken
+	jwt.ExpiresIn = int64(utils.AccessTokenTTL().Seconds())
+
+	return jwt, nil
+}
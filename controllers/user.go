@@ -3,21 +3,23 @@ package controllers
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
+	"github.com/jcprz/jwtapp/mailer"
 	"github.com/jcprz/jwtapp/models"
 	userRepository "github.com/jcprz/jwtapp/repository/user"
 	"github.com/jcprz/jwtapp/utils"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/go-redis/redis"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func (c Controller) Signup(db *sql.DB) http.HandlerFunc {
+// Signup creates an account whose email address still has to be verified and
+// mails the verification link, which points at APP_BASE_URL.
+func (c Controller) Signup(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	verifyURL := appBaseURL() + "/verify-email"
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		var user models.User
 
@@ -34,7 +36,11 @@ func (c Controller) Signup(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
+		if !validNewPassword(w, "password", user.Password, user.Email) {
+			return
+		}
+
+		hash, err := utils.HashPassword(user.Password)
 
 		if err != nil {
 			log.Printf("Error hashing password: %v", err)
@@ -42,24 +48,40 @@ func (c Controller) Signup(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		user.Password = string(hash)
+		user.Password = hash
+		user.EmailVerified = false
 
 		userRepo := userRepository.UserRepository{}
 		user = userRepo.Signup(db, user)
 
+		if user.ID != 0 && utils.EmailVerificationMode() != utils.EmailVerificationOff {
+			if err := sendVerificationEmail(redis, mail, user, verifyURL); err != nil {
+				log.Printf("Error sending verification email to user %d: %v", user.ID, err)
+			}
+		}
+
 		user.Password = ""
 		utils.ResponseJSON(w, http.StatusCreated, user)
 	}
 
 }
 
+// loginRequest is the body of /login. ClientID and Nonce are optional; when a
+// client ID is given an OpenID Connect ID token is returned too.
+type loginRequest struct {
+	models.User
+	ClientID string `json:"client_id"`
+	Nonce    string `json:"nonce"`
+}
+
 func (c Controller) Login(db *sql.DB, redis *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		var user models.User
-		var jwt models.JWT
+		var req loginRequest
 
-		json.NewDecoder(r.Body).Decode(&user)
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user := req.User
 
 		if user.Email == "" {
 			utils.RespondWithError(w, http.StatusBadRequest, "Email is missing.")
@@ -72,39 +94,85 @@ func (c Controller) Login(db *sql.DB, redis *redis.Client) http.HandlerFunc {
 		}
 
 		password := user.Password
+		email := user.Email
+
+		if status, message, retryAfter := loginBlock(redis, r, email); status != http.StatusOK {
+			setRetryAfter(w, retryAfter)
+			utils.RespondWithError(w, status, message)
+			return
+		}
 
 		userRepo := userRepository.UserRepository{}
 
 		user, err := userRepo.Login(db, redis, user)
 
-		hashedPassword := user.Password
+		if err != nil || !verifyLoginPassword(db, user, password) {
+			recordLoginFailure(redis, r, email)
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
+			return
+		}
 
-		token, err := utils.GenerateToken(user)
+		if allowed, _ := emailVerificationPolicy(user); !allowed {
+			utils.RespondWithError(w, http.StatusForbidden, "Email address has not been verified.")
+			return
+		}
+
+		amr := []string{utils.AMRPassword}
 
+		challenge, err := startMFAChallenge(db, redis, user, amr, req.ClientID, req.Nonce)
 		if err != nil {
-			log.Printf("Error generating token: %v", err)
-			utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token.")
+			log.Printf("Error starting MFA challenge for user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		if challenge.MFARequired {
+			utils.ResponseJSON(w, http.StatusOK, challenge)
 			return
 		}
 
-		isValidPasswd := utils.ComparePasswords(hashedPassword, []byte(password))
+		// With a second factor, the failures are only cleared once the
+		// challenge is completed.
+		resetLoginFailures(redis, r, email)
 
-		if isValidPasswd {
-			w.Header().Set("Authorization", token)
+		respondWithLogin(w, db, user, req.ClientID, req.Nonce, amr)
+	}
 
-			jwt.Token = token
-			utils.ResponseJSON(w, http.StatusOK, jwt)
+}
 
-		} else {
-			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
-		}
+// respondWithLogin finishes a successful login with the tokens for user, plus
+// an ID token when the login named a client.
+func respondWithLogin(w http.ResponseWriter, db *sql.DB, user models.User, clientID, nonce string, amr []string) {
+	var jwt models.JWT
+	var err error
 
+	if _, limited := emailVerificationPolicy(user); limited {
+		jwt, err = issueAccessToken(db, user, "", amr)
+	} else {
+		jwt, err = issueTokens(db, user, "", "", amr)
 	}
 
+	if err == nil && clientID != "" {
+		jwt.IDToken, err = utils.GenerateIDToken(user, clientID, nonce, time.Now(), amr)
+	}
+
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token.")
+		return
+	}
+
+	w.Header().Set("Authorization", jwt.Token)
+	utils.ResponseJSON(w, http.StatusOK, jwt)
 }
 
+// Delete removes the account with {"email": "..."}. Needs the users:delete
+// permission, so it has to be wrapped by RequireScope(redis, "users:delete").
 func (c Controller) Delete(db *sql.DB, redis *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireScopes(w, r, "users:delete") {
+			return
+		}
 
 		var user models.User
 
@@ -117,57 +185,46 @@ func (c Controller) Delete(db *sql.DB, redis *redis.Client) http.HandlerFunc {
 
 		userRepo := userRepository.UserRepository{}
 
-		err := userRepo.Delete(db, redis, user)
+		user, err := userRepo.Delete(db, redis, user)
 
 		w.Header().Set("Content-Type", "application/json")
 		if err != nil {
 			utils.RespondWithError(w, http.StatusNotFound, "User not found")
-		} else {
-			utils.ResponseJSON(w, http.StatusOK, "User has been deleted")
+			return
+		}
+
+		// Refresh tokens go away with the user row, access tokens need to be
+		// cut off explicitly.
+		if err := revokeUserSessions(db, redis, user.ID); err != nil {
+			log.Printf("Error revoking sessions of deleted user %s: %v", user.Email, err)
 		}
 
+		utils.ResponseJSON(w, http.StatusOK, "User has been deleted")
+
 	}
 
 }
 
-func (c Controller) TokenVerifyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// TokenVerifyMiddleware lets a request through only with a valid, unrevoked
+// access token, and puts who it speaks for in the request context, where
+// handlers find it with utils.PrincipalFromContext.
+func (c Controller) TokenVerifyMiddleware(redis *redis.Client, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bearerToken := r.Header.Get("Authorization")
-		var authHeader string
-
-		// Safely extract token from Bearer header
-		if bearerToken != "" {
-			parts := strings.Split(bearerToken, " ")
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				authHeader = parts[1]
-			}
-		}
+		authHeader := bearerToken(r)
 
 		if authHeader == "" {
 			utils.RespondWithError(w, http.StatusUnauthorized, "Missing or invalid Authorization header")
 			return
 		}
 
-		token, err := jwt.Parse(authHeader, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-
-			// Get JWT secret from Secrets Manager or environment variable
-			secret := utils.GetJWTSecretFromSecret()
-			return []byte(secret), nil
-		})
+		claims, status, message := verifyToken(redis, authHeader)
 
-		if err != nil {
-			utils.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		if status != http.StatusOK {
+			utils.RespondWithError(w, status, message)
 			return
 		}
 
-		if token.Valid {
-			next.ServeHTTP(w, r)
-		} else {
-			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
-			return
-		}
+		ctx := utils.ContextWithPrincipal(r.Context(), utils.NewPrincipal(claims))
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/mailer"
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	"github.com/jcprz/jwtapp/utils"
+)
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// sendVerificationEmail mails user a single-use link to verifyURL, which is
+// /verify-email under APP_BASE_URL.
+func sendVerificationEmail(redis *redis.Client, mail mailer.Mailer, user models.User, verifyURL string) error {
+	token, jti, err := utils.GenerateEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	if err := tokenRepo.SaveEmailVerification(redis, jti, utils.EmailVerificationTTL()); err != nil {
+		return err
+	}
+
+	msg, err := mailer.Render(user.Email, "verify_email", map[string]string{
+		"Link":     verifyURL + "?token=" + url.QueryEscape(token),
+		"ValidFor": validFor(utils.EmailVerificationTTL()),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(msg)
+}
+
+// sendNotification mails one of the security notification templates, only
+// logging failures: the change it reports has already happened.
+func sendNotification(mail mailer.Mailer, to, template string, data interface{}) {
+	msg, err := mailer.Render(to, template, data)
+	if err == nil {
+		err = mail.Send(msg)
+	}
+	if err != nil {
+		log.Printf("Error sending %s notification: %v", template, err)
+	}
+}
+
+// validFor spells out a link lifetime for people, e.g. "24 hours".
+func validFor(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0 && d != time.Hour:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d == time.Hour:
+		return "1 hour"
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// emailVerificationPolicy tells whether user may log in and, if so, whether
+// the session is limited to an access token because the email address hasn't
+// been verified yet.
+func emailVerificationPolicy(user models.User) (bool, bool) {
+	if user.EmailVerified {
+		return true, false
+	}
+
+	switch utils.EmailVerificationMode() {
+	case utils.EmailVerificationRequired:
+		return false, false
+	case utils.EmailVerificationLimited:
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// issueAccessToken is issueTokens without the refresh token, for sessions
+// that shouldn't outlive the access token.
+func issueAccessToken(db *sql.DB, user models.User, clientID string, amr []string) (models.JWT, error) {
+	var jwt models.JWT
+
+	user, err := withRoles(db, user, clientID)
+	if err != nil {
+		return jwt, err
+	}
+
+	token, err := utils.GenerateTokenForClient(user, clientID, amr)
+	if err != nil {
+		return jwt, err
+	}
+
+	jwt.Token = token
+	jwt.ExpiresIn = int64(utils.AccessTokenTTL().Seconds())
+
+	return jwt, nil
+}
+
+// VerifyEmail marks the email address a verification token was sent to as
+// verified. The token comes in the query string, as in the mailed link, or as
+// {"token": "..."} in the body.
+func (c Controller) VerifyEmail(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req verifyEmailRequest
+
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&req)
+		} else {
+			req.Token = r.URL.Query().Get("token")
+		}
+
+		if req.Token == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Token is missing.")
+			return
+		}
+
+		claims, err := utils.ParseEmailVerificationToken(req.Token)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token.")
+			return
+		}
+
+		sub, _ := claims.GetSubject()
+		userID, err := strconv.Atoi(sub)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token.")
+			return
+		}
+
+		jti, _ := claims["jti"].(string)
+		email, _ := claims["email"].(string)
+
+		tokenRepo := tokenRepository.TokenRepository{}
+		ok, err := tokenRepo.ConsumeEmailVerification(redis, jti)
+		if err != nil {
+			log.Printf("Error consuming email verification token: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to verify email address.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token.")
+			return
+		}
+
+		userRepo := userRepository.UserRepository{}
+		ok, err = userRepo.MarkEmailVerified(db, userID, email)
+		if err != nil {
+			log.Printf("Error marking email of user %d verified: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !ok {
+			// The account is gone or has changed its address since.
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, "Email address verified")
+	}
+}
+
+// ResendVerificationEmail sends a new verification link. The response is the
+// same whether or not the address belongs to an unverified account, and like
+// ForgotPassword the lookup and mailing happen after responding, so neither
+// the answer nor its timing shows who has signed up.
+func (c Controller) ResendVerificationEmail(db *sql.DB, redis *redis.Client, mail mailer.Mailer) http.HandlerFunc {
+	verifyURL := appBaseURL() + "/verify-email"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+
+		json.NewDecoder(r.Body).Decode(&user)
+
+		if user.Email == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Email is missing.")
+			return
+		}
+
+		go resendVerificationEmail(db, redis, mail, user.Email, verifyURL)
+
+		utils.ResponseJSON(w, http.StatusAccepted, "If the address belongs to an unverified account, a verification email is on its way")
+	}
+}
+
+func resendVerificationEmail(db *sql.DB, redis *redis.Client, mail mailer.Mailer, email, verifyURL string) {
+	userRepo := userRepository.UserRepository{}
+	user, err := userRepo.Login(db, redis, models.User{Email: email})
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up user for verification email: %v", err)
+		}
+		return
+	}
+
+	if user.EmailVerified || utils.EmailVerificationMode() == utils.EmailVerificationOff {
+		return
+	}
+
+	if err := sendVerificationEmail(redis, mail, user, verifyURL); err != nil {
+		log.Printf("Error sending verification email to user %d: %v", user.ID, err)
+	}
+}
@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/models"
+	tokenRepository "github.com/jcprz/jwtapp/repository/token"
+	userRepository "github.com/jcprz/jwtapp/repository/user"
+	webauthnRepository "github.com/jcprz/jwtapp/repository/webauthn"
+	"github.com/jcprz/jwtapp/utils"
+	"github.com/jcprz/jwtapp/webauthn"
+)
+
+// Ceremonies a WebAuthn challenge can be handed out for.
+const (
+	webAuthnRegistration = "registration"
+	webAuthnLogin        = "login"
+	webAuthnMFA          = "mfa"
+)
+
+var errWebAuthnSession = errors.New("invalid or expired WebAuthn challenge")
+
+type webAuthnRegistrationRequest struct {
+	Password   string                        `json:"password"`
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+type webAuthnLoginRequest struct {
+	ClientID   string                     `json:"client_id"`
+	Nonce      string                     `json:"nonce"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+type webAuthnCredentialRequest struct {
+	ID       int    `json:"id"`
+	Password string `json:"password"`
+}
+
+// webAuthnUserHandle is the user.id credentials are created with, which
+// authenticators hand back on passkey logins.
+func webAuthnUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+func toWebAuthnCredential(credential models.WebAuthnCredential) webauthn.Credential {
+	return webauthn.Credential{
+		ID:         credential.CredentialID,
+		PublicKey:  credential.PublicKey,
+		SignCount:  credential.SignCount,
+		Transports: credential.Transports,
+		AAGUID:     credential.AAGUID,
+	}
+}
+
+// webAuthnDescriptors lists the user's credentials for excludeCredentials or
+// allowCredentials.
+func webAuthnDescriptors(db *sql.DB, userID int) ([]webauthn.CredentialDescriptor, error) {
+	webauthnRepo := webauthnRepository.WebAuthnRepository{}
+
+	credentials, err := webauthnRepo.ListByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := []webauthn.CredentialDescriptor{}
+	for _, credential := range credentials {
+		descriptors = append(descriptors, toWebAuthnCredential(credential).Descriptor())
+	}
+
+	return descriptors, nil
+}
+
+// startWebAuthnSession hands out a challenge for a ceremony.
+func startWebAuthnSession(redis *redis.Client, session models.WebAuthnSession) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	err = tokenRepo.SaveWebAuthnSession(redis, base64.RawURLEncoding.EncodeToString(challenge), session, webauthn.ChallengeTimeout)
+
+	return challenge, err
+}
+
+// consumeWebAuthnSession ends the ceremony a response was made for and
+// returns it with its challenge. Unknown, expired or reused challenges and
+// ones of another kind of ceremony give errWebAuthnSession.
+func consumeWebAuthnSession(rds *redis.Client, clientDataJSON []byte, ceremony string) (models.WebAuthnSession, []byte, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return models.WebAuthnSession{}, nil, errWebAuthnSession
+	}
+
+	tokenRepo := tokenRepository.TokenRepository{}
+	session, err := tokenRepo.ConsumeWebAuthnSession(rds, base64.RawURLEncoding.EncodeToString(challenge))
+	if err == redis.Nil {
+		return models.WebAuthnSession{}, nil, errWebAuthnSession
+	}
+	if err != nil {
+		return models.WebAuthnSession{}, nil, err
+	}
+
+	if session.Ceremony != ceremony {
+		return models.WebAuthnSession{}, nil, errWebAuthnSession
+	}
+
+	return session, challenge, nil
+}
+
+// verifyWebAuthnAssertion checks an assertion against the stored credential
+// it names and records its use. The returned bool is false for anything that
+// doesn't check out.
+func verifyWebAuthnAssertion(db *sql.DB, resp webauthn.AssertionResponse, challenge []byte, requireUV bool) (models.WebAuthnCredential, bool, error) {
+	webauthnRepo := webauthnRepository.WebAuthnRepository{}
+
+	credential, err := webauthnRepo.GetByCredentialID(db, resp.RawID)
+	if err == sql.ErrNoRows {
+		return models.WebAuthnCredential{}, false, nil
+	}
+	if err != nil {
+		return models.WebAuthnCredential{}, false, err
+	}
+
+	if len(resp.Response.UserHandle) > 0 && !bytes.Equal(resp.Response.UserHandle, webAuthnUserHandle(credential.UserID)) {
+		return models.WebAuthnCredential{}, false, nil
+	}
+
+	signCount, err := webauthn.FromEnv().VerifyAssertion(resp, challenge, toWebAuthnCredential(credential), requireUV)
+	if err == webauthn.ErrSignCount {
+		log.Printf("WebAuthn credential %d of user %d sent a signature counter that did not increase, it may have been cloned", credential.ID, credential.UserID)
+		return models.WebAuthnCredential{}, false, nil
+	}
+	if err != nil {
+		log.Printf("Rejected WebAuthn assertion for credential %d: %v", credential.ID, err)
+		return models.WebAuthnCredential{}, false, nil
+	}
+
+	ok, err := webauthnRepo.UseCredential(db, credential, signCount)
+	if err != nil || !ok {
+		return models.WebAuthnCredential{}, false, err
+	}
+
+	return credential, true, nil
+}
+
+// verifyWebAuthnFactor checks an assertion made as the second factor of the
+// MFA challenge jti.
+func verifyWebAuthnFactor(db *sql.DB, redis *redis.Client, userID int, jti string, resp webauthn.AssertionResponse) (bool, error) {
+	session, challenge, err := consumeWebAuthnSession(redis, resp.Response.ClientDataJSON, webAuthnMFA)
+	if err == errWebAuthnSession {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if session.UserID != userID || session.MFAChallenge != jti {
+		return false, nil
+	}
+
+	credential, ok, err := verifyWebAuthnAssertion(db, resp, challenge, false)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return credential.UserID == userID, nil
+}
+
+// webAuthnMFAOptions starts the WebAuthn ceremony for an MFA challenge. The
+// returned bool is false when the token is invalid or the user has no
+// credentials.
+func webAuthnMFAOptions(db *sql.DB, redis *redis.Client, mfaToken string) (webauthn.RequestOptions, bool, error) {
+	claims, err := utils.ParseMFAChallengeToken(mfaToken)
+	if err != nil {
+		return webauthn.RequestOptions{}, false, nil
+	}
+
+	sub, _ := claims.GetSubject()
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return webauthn.RequestOptions{}, false, nil
+	}
+
+	allow, err := webAuthnDescriptors(db, userID)
+	if err != nil || len(allow) == 0 {
+		return webauthn.RequestOptions{}, false, err
+	}
+
+	jti, _ := claims["jti"].(string)
+
+	challenge, err := startWebAuthnSession(redis, models.WebAuthnSession{Ceremony: webAuthnMFA, UserID: userID, MFAChallenge: jti})
+	if err != nil {
+		return webauthn.RequestOptions{}, false, err
+	}
+
+	return webauthn.FromEnv().RequestOptions(challenge, allow, webauthn.UserVerificationDiscouraged), true, nil
+}
+
+// WebAuthnRegistrationOptions starts registering a passkey or security key
+// for the logged in user, who confirms with their password. It returns the
+// options for navigator.credentials.create(). Meant to be wrapped by
+// TokenVerifyMiddleware.
+func (c Controller) WebAuthnRegistrationOptions(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req webAuthnRegistrationRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user, _, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		exclude, err := webAuthnDescriptors(db, user.ID)
+		if err != nil {
+			log.Printf("Error looking up WebAuthn credentials of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		challenge, err := startWebAuthnSession(redis, models.WebAuthnSession{Ceremony: webAuthnRegistration, UserID: user.ID})
+		if err != nil {
+			log.Printf("Error starting WebAuthn registration: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to start registration.")
+			return
+		}
+
+		userEntity := webauthn.UserEntity{
+			ID:          webAuthnUserHandle(user.ID),
+			Name:        user.Email,
+			DisplayName: user.Email,
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		utils.ResponseJSON(w, http.StatusOK, webauthn.FromEnv().CreationOptions(challenge, userEntity, exclude))
+	}
+}
+
+// RegisterWebAuthn finishes a registration with the credential
+// navigator.credentials.create() returned, in its toJSON() form. Meant to be
+// wrapped by TokenVerifyMiddleware.
+func (c Controller) RegisterWebAuthn(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req webAuthnRegistrationRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		userID, err := requestUserID(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		if len(req.Name) > 100 {
+			utils.RespondWithError(w, http.StatusBadRequest, "Name is too long.")
+			return
+		}
+
+		session, challenge, err := consumeWebAuthnSession(redis, req.Credential.Response.ClientDataJSON, webAuthnRegistration)
+		if err == nil && session.UserID != userID {
+			err = errWebAuthnSession
+		}
+		if err == errWebAuthnSession {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired challenge.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up WebAuthn registration: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to verify credential.")
+			return
+		}
+
+		credential, err := webauthn.FromEnv().VerifyRegistration(req.Credential, challenge, false)
+		if err != nil {
+			log.Printf("Rejected WebAuthn registration of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid credential.")
+			return
+		}
+
+		webauthnRepo := webauthnRepository.WebAuthnRepository{}
+		stored, err := webauthnRepo.Create(db, models.WebAuthnCredential{
+			UserID:       userID,
+			CredentialID: credential.ID,
+			Name:         req.Name,
+			PublicKey:    credential.PublicKey,
+			SignCount:    credential.SignCount,
+			Transports:   credential.Transports,
+			AAGUID:       credential.AAGUID,
+		})
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusConflict, "Credential is already registered.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error storing WebAuthn credential of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusCreated, stored)
+	}
+}
+
+// WebAuthnCredentials lists the passkeys and security keys of the logged in
+// user. Meant to be wrapped by TokenVerifyMiddleware.
+func (c Controller) WebAuthnCredentials(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := requestUserID(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		webauthnRepo := webauthnRepository.WebAuthnRepository{}
+		credentials, err := webauthnRepo.ListByUser(db, userID)
+		if err != nil {
+			log.Printf("Error looking up WebAuthn credentials of user %d: %v", userID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, credentials)
+	}
+}
+
+// DeleteWebAuthnCredential removes one of the logged in user's credentials,
+// confirmed with their password. Meant to be wrapped by
+// TokenVerifyMiddleware.
+func (c Controller) DeleteWebAuthnCredential(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req webAuthnCredentialRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		user, _, ok := authenticatedUser(db, redis, w, r, req.Password)
+		if !ok {
+			return
+		}
+
+		webauthnRepo := webauthnRepository.WebAuthnRepository{}
+		ok, err := webauthnRepo.Delete(db, user.ID, req.ID)
+		if err != nil {
+			log.Printf("Error deleting WebAuthn credential of user %d: %v", user.ID, err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusNotFound, "Credential not found.")
+			return
+		}
+
+		utils.ResponseJSON(w, http.StatusOK, "Credential deleted")
+	}
+}
+
+// WebAuthnLoginOptions starts a passwordless login. The options leave
+// allowCredentials empty so the browser offers whichever passkeys the user
+// has for the site, without the server revealing which accounts exist.
+func (c Controller) WebAuthnLoginOptions(redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := startWebAuthnSession(redis, models.WebAuthnSession{Ceremony: webAuthnLogin})
+		if err != nil {
+			log.Printf("Error starting WebAuthn login: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to start login.")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		utils.ResponseJSON(w, http.StatusOK, webauthn.FromEnv().RequestOptions(challenge, nil, webauthn.UserVerificationRequired))
+	}
+}
+
+// LoginWebAuthn finishes a passwordless login with the credential
+// navigator.credentials.get() returned and responds like Login does. The
+// authenticator must have verified the user, so the passkey counts as
+// multi-factor on its own and no MFA challenge follows.
+func (c Controller) LoginWebAuthn(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req webAuthnLoginRequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		_, challenge, err := consumeWebAuthnSession(redis, req.Credential.Response.ClientDataJSON, webAuthnLogin)
+		if err == errWebAuthnSession {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up WebAuthn login: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to verify credential.")
+			return
+		}
+
+		credential, ok, err := verifyWebAuthnAssertion(db, req.Credential, challenge, true)
+		if err != nil {
+			log.Printf("Error verifying WebAuthn login: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Server Error.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
+			return
+		}
+
+		userRepo := userRepository.UserRepository{}
+		user, err := userRepo.GetByID(db, credential.UserID)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid credentials.")
+			return
+		}
+
+		if allowed, _ := emailVerificationPolicy(user); !allowed {
+			utils.RespondWithError(w, http.StatusForbidden, "Email address has not been verified.")
+			return
+		}
+
+		respondWithLogin(w, db, user, req.ClientID, req.Nonce, []string{utils.AMRHardwareKey, utils.AMRMultiFactor})
+	}
+}
+
+// WebAuthnMFAOptions returns the options for navigator.credentials.get() to
+// complete an MFA challenge with a passkey or security key, for users whose
+// challenge lists the "webauthn" method.
+func (c Controller) WebAuthnMFAOptions(db *sql.DB, redis *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req loginMFARequest
+
+		json.NewDecoder(r.Body).Decode(&req)
+
+		options, ok, err := webAuthnMFAOptions(db, redis, req.MFAToken)
+		if err != nil {
+			log.Printf("Error starting WebAuthn MFA: %v", err)
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Unable to start WebAuthn.")
+			return
+		}
+		if !ok {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token.")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		utils.ResponseJSON(w, http.StatusOK, options)
+	}
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+                       ID  SERIAL PRIMARY KEY,
+                       FAMILY_ID VARCHAR(64) NOT NULL,
+                       USER_ID INTEGER NOT NULL REFERENCES users (ID) ON DELETE CASCADE,
+                       TOKEN_HASH VARCHAR(64) NOT NULL UNIQUE,
+                       EXPIRES_AT TIMESTAMPTZ NOT NULL,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       USED_AT TIMESTAMPTZ,
+                       REVOKED_AT TIMESTAMPTZ
+                   );
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (FAMILY_ID);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (USER_ID);
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS CLIENT_ID;
+DROP TABLE IF EXISTS oauth_clients;
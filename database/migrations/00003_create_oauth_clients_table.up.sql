@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+                       ID  SERIAL PRIMARY KEY,
+                       CLIENT_ID VARCHAR(64) NOT NULL UNIQUE,
+                       NAME VARCHAR(100) NOT NULL,
+                       REDIRECT_URIS TEXT[] NOT NULL DEFAULT '{}',
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+                   );
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS CLIENT_ID VARCHAR(64);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS oauth_client_secrets;
+ALTER TABLE oauth_clients DROP CONSTRAINT IF EXISTS oauth_clients_client_id_not_numeric;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS ALLOWED_SCOPES;
@@ -0,0 +1,23 @@
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS ALLOWED_SCOPES TEXT[] NOT NULL DEFAULT '{}';
+
+-- Client IDs end up in the sub claim next to numeric user IDs, keep them apart.
+-- Only added when missing, so running this again doesn't lock and rescan the table.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'oauth_clients_client_id_not_numeric' AND conrelid = 'oauth_clients'::regclass) THEN
+        ALTER TABLE oauth_clients ADD CONSTRAINT oauth_clients_client_id_not_numeric CHECK (CLIENT_ID !~ '^[0-9]+$');
+    END IF;
+EXCEPTION
+    WHEN duplicate_object THEN NULL;
+END $$;
+
+-- A client can have more than one secret at a time so secrets can be rotated.
+CREATE TABLE IF NOT EXISTS oauth_client_secrets (
+                       ID  SERIAL PRIMARY KEY,
+                       CLIENT_ID VARCHAR(64) NOT NULL REFERENCES oauth_clients (CLIENT_ID) ON DELETE CASCADE,
+                       SECRET_HASH VARCHAR(100) NOT NULL,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       EXPIRES_AT TIMESTAMPTZ
+                   );
+
+CREATE INDEX IF NOT EXISTS oauth_client_secrets_client_id_idx ON oauth_client_secrets (CLIENT_ID);
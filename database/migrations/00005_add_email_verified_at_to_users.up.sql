@@ -0,0 +1,3 @@
+-- Existing accounts count as verified, new ones start out unverified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS EMAIL_VERIFIED_AT TIMESTAMPTZ DEFAULT NOW();
+ALTER TABLE users ALTER COLUMN EMAIL_VERIFIED_AT DROP DEFAULT;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+                       ID  SERIAL PRIMARY KEY,
+                       USER_ID INTEGER NOT NULL REFERENCES users (ID) ON DELETE CASCADE,
+                       TOKEN_HASH VARCHAR(64) NOT NULL UNIQUE,
+                       EXPIRES_AT TIMESTAMPTZ NOT NULL,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       USED_AT TIMESTAMPTZ
+                   );
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id_idx ON password_reset_tokens (USER_ID);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_totp;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS AMR;
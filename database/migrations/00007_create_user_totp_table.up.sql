@@ -0,0 +1,9 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS AMR TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS user_totp (
+                       USER_ID INTEGER PRIMARY KEY REFERENCES users (ID) ON DELETE CASCADE,
+                       SECRET VARCHAR(64) NOT NULL,
+                       CONFIRMED_AT TIMESTAMPTZ,
+                       LAST_COUNTER BIGINT NOT NULL DEFAULT 0,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+                   );
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+                       ID  SERIAL PRIMARY KEY,
+                       USER_ID INTEGER NOT NULL REFERENCES users (ID) ON DELETE CASCADE,
+                       CODE_HASH VARCHAR(64) NOT NULL,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       USED_AT TIMESTAMPTZ,
+                       UNIQUE (USER_ID, CODE_HASH)
+                   );
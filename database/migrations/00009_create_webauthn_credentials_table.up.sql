@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+                       ID  SERIAL PRIMARY KEY,
+                       USER_ID INTEGER NOT NULL REFERENCES users (ID) ON DELETE CASCADE,
+                       CREDENTIAL_ID BYTEA NOT NULL UNIQUE,
+                       NAME VARCHAR(100) NOT NULL DEFAULT '',
+                       PUBLIC_KEY BYTEA NOT NULL,
+                       SIGN_COUNT BIGINT NOT NULL DEFAULT 0,
+                       TRANSPORTS TEXT[] NOT NULL DEFAULT '{}',
+                       AAGUID BYTEA,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       LAST_USED_AT TIMESTAMPTZ
+                   );
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (USER_ID);
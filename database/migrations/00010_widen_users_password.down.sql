@@ -0,0 +1 @@
+ALTER TABLE users ALTER COLUMN PASSWORD TYPE VARCHAR(100);
@@ -0,0 +1,2 @@
+-- argon2id hashes in the PHC string format outgrow VARCHAR(100).
+ALTER TABLE users ALTER COLUMN PASSWORD TYPE VARCHAR(255);
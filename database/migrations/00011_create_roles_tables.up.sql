@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS roles (
+                       ID  SERIAL PRIMARY KEY,
+                       NAME VARCHAR(64) NOT NULL UNIQUE,
+                       DESCRIPTION VARCHAR(255) NOT NULL DEFAULT '',
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+                   );
+
+CREATE TABLE IF NOT EXISTS permissions (
+                       ID  SERIAL PRIMARY KEY,
+                       NAME VARCHAR(64) NOT NULL UNIQUE,
+                       DESCRIPTION VARCHAR(255) NOT NULL DEFAULT ''
+                   );
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+                       ROLE_ID INTEGER NOT NULL REFERENCES roles (ID) ON DELETE CASCADE,
+                       PERMISSION_ID INTEGER NOT NULL REFERENCES permissions (ID) ON DELETE CASCADE,
+                       PRIMARY KEY (ROLE_ID, PERMISSION_ID)
+                   );
+
+CREATE TABLE IF NOT EXISTS user_roles (
+                       USER_ID INTEGER NOT NULL REFERENCES users (ID) ON DELETE CASCADE,
+                       ROLE_ID INTEGER NOT NULL REFERENCES roles (ID) ON DELETE CASCADE,
+                       CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                       PRIMARY KEY (USER_ID, ROLE_ID)
+                   );
+
+CREATE INDEX IF NOT EXISTS user_roles_role_id_idx ON user_roles (ROLE_ID);
+
+-- The permissions the API itself checks, granted to an admin role.
+INSERT INTO permissions (NAME, DESCRIPTION) VALUES
+    ('users:delete', 'Delete user accounts'),
+    ('roles:assign', 'Grant and revoke roles')
+ON CONFLICT (NAME) DO NOTHING;
+
+INSERT INTO roles (NAME, DESCRIPTION) VALUES ('admin', 'Manages users and their roles')
+ON CONFLICT (NAME) DO NOTHING;
+
+INSERT INTO role_permissions (ROLE_ID, PERMISSION_ID)
+SELECT r.ID, p.ID FROM roles r, permissions p WHERE r.NAME = 'admin' AND p.NAME IN ('users:delete', 'roles:assign')
+ON CONFLICT DO NOTHING;
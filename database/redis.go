@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/utils"
 	// Redis 6
 )
 
@@ -15,11 +16,14 @@ func ConnectRedis() *redis.Client {
 
 	redisHost := os.Getenv("REDIS_HOST")
 	redisPort := os.Getenv("REDIS_PORT")
-	redisPassword := os.Getenv("REDIS_PASSWORD")
 
-	rdsToStr := fmt.Sprintf("host=%s port=%s password=%s sslmode=disable\n", redisHost, redisPort, redisPassword)
+	// Get password from the configured secrets provider
+	redisPassword, err := utils.GetRedisPasswordFromSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Printf("Redis connection details: %s", rdsToStr)
+	log.Printf("Redis connection details: host=%s port=%s", redisHost, redisPort)
 	redisAddr := fmt.Sprintf("%s:%s", redisHost, redisPort)
 
 	client := redis.NewClient(&redis.Options{
@@ -29,7 +33,7 @@ func ConnectRedis() *redis.Client {
 		DB:       0, //  default DB
 	})
 
-	_, err := client.Ping().Result()
+	_, err = client.Ping().Result()
 	if err != nil {
 		log.Fatalf("Unable to acquire connection with Redis: %s", err)
 	}
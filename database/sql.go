@@ -19,7 +19,10 @@ func ConnectDB() *sql.DB {
 	dbDialect := os.Getenv("DB_DIALECT")
 
 	// Get password from Secrets Manager or environment variable
-	dbPass := utils.GetDBPasswordFromSecret()
+	dbPass, err := utils.GetDBPasswordFromSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	dbToStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbPass, dbName)
 
@@ -43,6 +46,135 @@ func EnsureTableExists(db *sql.DB) error {
 	if err != nil {
 		log.Panicf("Cannot create table. Error: %s", err)
 	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS REFRESH_TOKENS (ID SERIAL PRIMARY KEY, FAMILY_ID VARCHAR(64) NOT NULL, USER_ID INTEGER NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE, TOKEN_HASH VARCHAR(64) NOT NULL UNIQUE, EXPIRES_AT TIMESTAMPTZ NOT NULL, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(), USED_AT TIMESTAMPTZ, REVOKED_AT TIMESTAMPTZ);")
+
+	if err != nil {
+		log.Panicf("Cannot create refresh_tokens table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS OAUTH_CLIENTS (ID SERIAL PRIMARY KEY, CLIENT_ID VARCHAR(64) NOT NULL UNIQUE, NAME VARCHAR(100) NOT NULL, REDIRECT_URIS TEXT[] NOT NULL DEFAULT '{}', CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW());")
+
+	if err != nil {
+		log.Panicf("Cannot create oauth_clients table. Error: %s", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE REFRESH_TOKENS ADD COLUMN IF NOT EXISTS CLIENT_ID VARCHAR(64);")
+
+	if err != nil {
+		log.Panicf("Cannot add client_id to refresh_tokens table. Error: %s", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE OAUTH_CLIENTS ADD COLUMN IF NOT EXISTS ALLOWED_SCOPES TEXT[] NOT NULL DEFAULT '{}';")
+
+	if err != nil {
+		log.Panicf("Cannot add allowed_scopes to oauth_clients table. Error: %s", err)
+	}
+
+	// Client IDs end up in the sub claim next to numeric user IDs, keep them
+	// apart. Only added when missing, since adding it locks and scans the
+	// table; a concurrent startup adding it first is fine too.
+	_, err = db.Exec("DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM PG_CONSTRAINT WHERE CONNAME = 'oauth_clients_client_id_not_numeric' AND CONRELID = 'oauth_clients'::REGCLASS) THEN ALTER TABLE OAUTH_CLIENTS ADD CONSTRAINT OAUTH_CLIENTS_CLIENT_ID_NOT_NUMERIC CHECK (CLIENT_ID !~ '^[0-9]+$'); END IF; EXCEPTION WHEN DUPLICATE_OBJECT THEN NULL; END $$;")
+
+	if err != nil {
+		log.Panicf("Cannot add client_id check to oauth_clients table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS OAUTH_CLIENT_SECRETS (ID SERIAL PRIMARY KEY, CLIENT_ID VARCHAR(64) NOT NULL REFERENCES OAUTH_CLIENTS (CLIENT_ID) ON DELETE CASCADE, SECRET_HASH VARCHAR(100) NOT NULL, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(), EXPIRES_AT TIMESTAMPTZ);")
+
+	if err != nil {
+		log.Panicf("Cannot create oauth_client_secrets table. Error: %s", err)
+	}
+
+	// Accounts that existed before verification was introduced count as
+	// verified: the column is added with a default that fills them in, which
+	// is then dropped so new accounts start out unverified.
+	_, err = db.Exec("ALTER TABLE USERS ADD COLUMN IF NOT EXISTS EMAIL_VERIFIED_AT TIMESTAMPTZ DEFAULT NOW();")
+
+	if err != nil {
+		log.Panicf("Cannot add email_verified_at to users table. Error: %s", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE USERS ALTER COLUMN EMAIL_VERIFIED_AT DROP DEFAULT;")
+
+	if err != nil {
+		log.Panicf("Cannot add email_verified_at to users table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS PASSWORD_RESET_TOKENS (ID SERIAL PRIMARY KEY, USER_ID INTEGER NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE, TOKEN_HASH VARCHAR(64) NOT NULL UNIQUE, EXPIRES_AT TIMESTAMPTZ NOT NULL, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(), USED_AT TIMESTAMPTZ);")
+
+	if err != nil {
+		log.Panicf("Cannot create password_reset_tokens table. Error: %s", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE REFRESH_TOKENS ADD COLUMN IF NOT EXISTS AMR TEXT[] NOT NULL DEFAULT '{}';")
+
+	if err != nil {
+		log.Panicf("Cannot add amr to refresh_tokens table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS USER_TOTP (USER_ID INTEGER PRIMARY KEY REFERENCES USERS (ID) ON DELETE CASCADE, SECRET VARCHAR(64) NOT NULL, CONFIRMED_AT TIMESTAMPTZ, LAST_COUNTER BIGINT NOT NULL DEFAULT 0, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW());")
+
+	if err != nil {
+		log.Panicf("Cannot create user_totp table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS MFA_RECOVERY_CODES (ID SERIAL PRIMARY KEY, USER_ID INTEGER NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE, CODE_HASH VARCHAR(64) NOT NULL, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(), USED_AT TIMESTAMPTZ, UNIQUE (USER_ID, CODE_HASH));")
+
+	if err != nil {
+		log.Panicf("Cannot create mfa_recovery_codes table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS WEBAUTHN_CREDENTIALS (ID SERIAL PRIMARY KEY, USER_ID INTEGER NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE, CREDENTIAL_ID BYTEA NOT NULL UNIQUE, NAME VARCHAR(100) NOT NULL DEFAULT '', PUBLIC_KEY BYTEA NOT NULL, SIGN_COUNT BIGINT NOT NULL DEFAULT 0, TRANSPORTS TEXT[] NOT NULL DEFAULT '{}', AAGUID BYTEA, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(), LAST_USED_AT TIMESTAMPTZ);")
+
+	if err != nil {
+		log.Panicf("Cannot create webauthn_credentials table. Error: %s", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE USERS ALTER COLUMN PASSWORD TYPE VARCHAR(255);")
+
+	if err != nil {
+		log.Panicf("Cannot widen password column of users table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS ROLES (ID SERIAL PRIMARY KEY, NAME VARCHAR(64) NOT NULL UNIQUE, DESCRIPTION VARCHAR(255) NOT NULL DEFAULT '', CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW());")
+
+	if err != nil {
+		log.Panicf("Cannot create roles table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS PERMISSIONS (ID SERIAL PRIMARY KEY, NAME VARCHAR(64) NOT NULL UNIQUE, DESCRIPTION VARCHAR(255) NOT NULL DEFAULT '');")
+
+	if err != nil {
+		log.Panicf("Cannot create permissions table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS ROLE_PERMISSIONS (ROLE_ID INTEGER NOT NULL REFERENCES ROLES (ID) ON DELETE CASCADE, PERMISSION_ID INTEGER NOT NULL REFERENCES PERMISSIONS (ID) ON DELETE CASCADE, PRIMARY KEY (ROLE_ID, PERMISSION_ID));")
+
+	if err != nil {
+		log.Panicf("Cannot create role_permissions table. Error: %s", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS USER_ROLES (USER_ID INTEGER NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE, ROLE_ID INTEGER NOT NULL REFERENCES ROLES (ID) ON DELETE CASCADE, CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(), PRIMARY KEY (USER_ID, ROLE_ID));")
+
+	if err != nil {
+		log.Panicf("Cannot create user_roles table. Error: %s", err)
+	}
+
+	// The permissions the API itself checks, granted to an admin role.
+	_, err = db.Exec("INSERT INTO PERMISSIONS (NAME, DESCRIPTION) VALUES ('users:delete', 'Delete user accounts'), ('roles:assign', 'Grant and revoke roles') ON CONFLICT (NAME) DO NOTHING;")
+
+	if err == nil {
+		_, err = db.Exec("INSERT INTO ROLES (NAME, DESCRIPTION) VALUES ('admin', 'Manages users and their roles') ON CONFLICT (NAME) DO NOTHING;")
+	}
+
+	if err == nil {
+		_, err = db.Exec("INSERT INTO ROLE_PERMISSIONS (ROLE_ID, PERMISSION_ID) SELECT R.ID, P.ID FROM ROLES R, PERMISSIONS P WHERE R.NAME = 'admin' AND P.NAME IN ('users:delete', 'roles:assign') ON CONFLICT DO NOTHING;")
+	}
+
+	if err != nil {
+		log.Panicf("Cannot seed roles. Error: %s", err)
+	}
 	log.Println("Table is created")
 	return nil
 }
@@ -278,15 +278,31 @@ func TestDeleteUserAPI(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	executeRequest(req)
 
+	token := adminToken(t, "admin@example.com")
+	userToken := loginToken(t, "delete@example.com", "password123")
+
 	tests := []struct {
 		name           string
 		payload        string
+		token          string
 		expectedStatus int
 		checkResponse  func(*testing.T, *httptest.ResponseRecorder)
 	}{
+		{
+			name:           "Missing token",
+			payload:        `{"email":"delete@example.com"}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Missing users:delete scope",
+			payload:        `{"email":"delete@example.com"}`,
+			token:          userToken,
+			expectedStatus: http.StatusForbidden,
+		},
 		{
 			name:           "Valid deletion",
 			payload:        `{"email":"delete@example.com"}`,
+			token:          token,
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, response *httptest.ResponseRecorder) {
 				body := response.Body.String()
@@ -298,6 +314,7 @@ func TestDeleteUserAPI(t *testing.T) {
 		{
 			name:           "Delete non-existent user",
 			payload:        `{"email":"nonexistent@example.com"}`,
+			token:          token,
 			expectedStatus: http.StatusNotFound,
 			checkResponse: func(t *testing.T, response *httptest.ResponseRecorder) {
 				var m map[string]interface{}
@@ -311,6 +328,7 @@ func TestDeleteUserAPI(t *testing.T) {
 		{
 			name:           "Missing email",
 			payload:        `{}`,
+			token:          token,
 			expectedStatus: http.StatusBadRequest,
 			checkResponse: func(t *testing.T, response *httptest.ResponseRecorder) {
 				var m map[string]interface{}
@@ -327,6 +345,9 @@ func TestDeleteUserAPI(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req, _ := http.NewRequest("DELETE", "/delete", bytes.NewBuffer([]byte(tt.payload)))
 			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tt.token))
+			}
 
 			response := executeRequest(req)
 			checkResponseCode(t, tt.expectedStatus, response.Code)
@@ -371,10 +392,11 @@ func TestFullUserLifecycle(t *testing.T) {
 	response = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, response.Code)
 
-	// 4. Delete user
+	// 4. Delete user, which only an admin may do
 	deletePayload := fmt.Sprintf(`{"email":"%s"}`, email)
 	req, _ = http.NewRequest("DELETE", "/delete", bytes.NewBuffer([]byte(deletePayload)))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", adminToken(t, "lifecycle-admin@example.com")))
 	response = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, response.Code)
 
@@ -388,6 +410,85 @@ func TestFullUserLifecycle(t *testing.T) {
 	}
 }
 
+func TestRefreshRejectsOAuthClientTokens(t *testing.T) {
+	clearTable()
+
+	email := "refresh@example.com"
+	password := "password123"
+
+	signupPayload := fmt.Sprintf(`{"email":"%s", "password":"%s"}`, email, password)
+	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer([]byte(signupPayload)))
+	req.Header.Set("Content-Type", "application/json")
+	executeRequest(req)
+
+	loginPayload := fmt.Sprintf(`{"email":"%s", "password":"%s"}`, email, password)
+	req, _ = http.NewRequest("POST", "/login", bytes.NewBuffer([]byte(loginPayload)))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var loginResult models.JWT
+	json.Unmarshal(response.Body.Bytes(), &loginResult)
+	if loginResult.RefreshToken == "" {
+		t.Fatal("Expected refresh token from login")
+	}
+
+	// Hand the session to an OAuth client, as /token would have.
+	setClient := "UPDATE REFRESH_TOKENS SET CLIENT_ID = $1 WHERE USER_ID = (SELECT ID FROM USERS WHERE EMAIL = $2)"
+	if _, err := a.DB.Exec(setClient, "my-spa", email); err != nil {
+		t.Fatalf("Cannot assign the refresh token to a client: %s", err)
+	}
+
+	refreshPayload := fmt.Sprintf(`{"refresh_token":"%s"}`, loginResult.RefreshToken)
+	req, _ = http.NewRequest("POST", "/token/refresh", bytes.NewBuffer([]byte(refreshPayload)))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// The refused attempt must not have used the token up.
+	if _, err := a.DB.Exec(setClient, nil, email); err != nil {
+		t.Fatalf("Cannot take the refresh token back from the client: %s", err)
+	}
+
+	req, _ = http.NewRequest("POST", "/token/refresh", bytes.NewBuffer([]byte(refreshPayload)))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+// loginToken logs in and returns the access token.
+func loginToken(t *testing.T, email, password string) string {
+	payload := fmt.Sprintf(`{"email":"%s", "password":"%s"}`, email, password)
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBuffer([]byte(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var result models.JWT
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Token == "" {
+		t.Fatal("Expected token from login")
+	}
+
+	return result.Token
+}
+
+// adminToken signs up email, grants it the admin role and returns a token
+// carrying the admin permissions.
+func adminToken(t *testing.T, email string) string {
+	password := "admin password 1"
+	payload := fmt.Sprintf(`{"email":"%s", "password":"%s"}`, email, password)
+	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer([]byte(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	executeRequest(req)
+
+	if _, err := a.DB.Exec("INSERT INTO USER_ROLES (USER_ID, ROLE_ID) SELECT U.ID, R.ID FROM USERS U, ROLES R WHERE U.EMAIL = $1 AND R.NAME = 'admin' ON CONFLICT DO NOTHING;", email); err != nil {
+		t.Fatalf("Cannot grant the admin role: %s", err)
+	}
+
+	return loginToken(t, email, password)
+}
+
 func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	rec := httptest.NewRecorder()
 	a.Router.ServeHTTP(rec, req)
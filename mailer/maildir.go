@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var maildirCounter uint64
+
+// MaildirMailer delivers messages into a local Maildir instead of sending
+// them, for development and tests. Any mail client that reads Maildir can
+// open it, and each message is also a plain .eml file under new/.
+type MaildirMailer struct {
+	Dir  string
+	From string
+}
+
+func (m MaildirMailer) Send(msg Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0o700); err != nil {
+			return fmt.Errorf("unable to create maildir: %w", err)
+		}
+	}
+
+	data, err := msg.Bytes(m.From)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().UnixNano(), os.Getpid(), atomic.AddUint64(&maildirCounter, 1), hostname)
+
+	// Write to tmp/ and move into new/, so readers never see half a message.
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("unable to write message: %w", err)
+	}
+
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jcprz/jwtapp/utils"
+)
+
+// Message is an email ready to be sent. Text is required, HTML is optional.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email. Controllers only depend on this interface so the
+// transport can be swapped per environment.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them. It is meant
+// for local development; the log will contain whatever links the message
+// carries.
+type LogMailer struct{}
+
+func (m LogMailer) Send(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// FromEnv returns the mailer selected by MAILER:
+//
+//   - "log" (default) writes messages to the log.
+//   - "maildir" delivers them into the Maildir at MAILDIR (default ./maildir).
+//   - "smtp" sends them through SMTP_HOST and SMTP_PORT (default 587), logging
+//     in with SMTP_USERNAME and the smtp_password secret when a username is
+//     set. SMTP_TLS=implicit connects with TLS from the start, as port 465
+//     expects; otherwise STARTTLS is used when offered.
+//
+// MAIL_FROM is the sender address.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "jwtapp <no-reply@localhost>"
+	}
+
+	switch name := os.Getenv("MAILER"); name {
+	case "", "log":
+		return LogMailer{}, nil
+	case "maildir":
+		dir := os.Getenv("MAILDIR")
+		if dir == "" {
+			dir = "maildir"
+		}
+		return MaildirMailer{Dir: dir, From: from}, nil
+	case "smtp":
+		return smtpMailerFromEnv(from)
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", name)
+	}
+}
+
+func smtpMailerFromEnv(from string) (Mailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required for the smtp mailer")
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	mailer := SMTPMailer{
+		Host:        host,
+		Port:        port,
+		Username:    os.Getenv("SMTP_USERNAME"),
+		From:        from,
+		ImplicitTLS: os.Getenv("SMTP_TLS") == "implicit",
+	}
+
+	if mailer.Username != "" {
+		password, err := utils.GetSMTPPasswordFromSecret()
+		if err != nil {
+			return nil, err
+		}
+		mailer.Password = password
+	}
+
+	return mailer, nil
+}
@@ -0,0 +1,226 @@
+package mailer
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	msg, err := Render("test@example.com", "verify_email", map[string]string{
+		"Link":     "https://auth.example.com/verify-email?token=a&b",
+		"ValidFor": "24 hours",
+	})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	if msg.To != "test@example.com" || msg.Subject != "Verify your email address" {
+		t.Errorf("Unexpected recipient or subject: %q, %q", msg.To, msg.Subject)
+	}
+
+	if !strings.Contains(msg.Text, "https://auth.example.com/verify-email?token=a&b") {
+		t.Errorf("Text body is missing the link: %s", msg.Text)
+	}
+
+	if !strings.Contains(msg.HTML, `href="https://auth.example.com/verify-email?token=a&amp;b"`) {
+		t.Errorf("HTML body is missing the escaped link: %s", msg.HTML)
+	}
+
+	if _, err := Render("test@example.com", "no_such_template", nil); err == nil {
+		t.Error("Expected an error for an unknown template")
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	data, err := Message{
+		To:      "test@example.com",
+		Subject: "Hello\r\nBcc: victim@example.com",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	}.Bytes("jwtapp <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("Bytes() returned error: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Unable to parse message: %v", err)
+	}
+
+	if parsed.Header.Get("Bcc") != "" {
+		t.Error("Header injection through the subject was not prevented")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q", parsed.Header.Get("Content-Type"))
+	}
+
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, string(body))
+	}
+
+	if len(bodies) != 2 || bodies[0] != "plain body" || bodies[1] != "<p>html body</p>" {
+		t.Errorf("Unexpected bodies %q", bodies)
+	}
+}
+
+func TestMessageBytesTextOnly(t *testing.T) {
+	data, err := Message{
+		To:      "test@example.com",
+		Subject: "Hello",
+		Text:    "plain body\nwith a second line = ünïcode",
+	}.Bytes("no-reply@example.com")
+	if err != nil {
+		t.Fatalf("Bytes() returned error: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Unable to parse message: %v", err)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type")); mediaType != "text/plain" {
+		t.Fatalf("Expected text/plain, got %q", parsed.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatalf("Unable to decode body: %v", err)
+	}
+
+	if string(body) != "plain body\r\nwith a second line = ünïcode" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestMaildirMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := MaildirMailer{Dir: dir, From: "no-reply@example.com"}
+
+	if err := mailer.Send(Message{To: "test@example.com", Subject: "Hello", Text: "body"}); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one message in new/, got %d", len(files))
+	}
+
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "tmp", "*")); len(tmp) != 0 {
+		t.Errorf("Expected tmp/ to be empty, got %v", tmp)
+	}
+
+	f, _ := os.Open(files[0])
+	defer f.Close()
+
+	parsed, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("Unable to parse message: %v", err)
+	}
+	if parsed.Header.Get("To") != "test@example.com" {
+		t.Errorf("Unexpected recipient %q", parsed.Header.Get("To"))
+	}
+}
+
+// fakeSMTPServer accepts a single plain-text SMTP session and hands the
+// envelope and data to the returned channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan []string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var session []string
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch command {
+			case "EHLO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				session = append(session, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				session = append(session, data.String())
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				received <- session
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	mailer := SMTPMailer{Host: host, Port: port, From: "jwtapp <no-reply@example.com>"}
+	if err := mailer.Send(Message{To: "test@example.com", Subject: "Hello", Text: "body"}); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	session := <-received
+	if len(session) != 3 {
+		t.Fatalf("Unexpected session %q", session)
+	}
+	if session[0] != "MAIL FROM:<no-reply@example.com>" || session[1] != "RCPT TO:<test@example.com>" {
+		t.Errorf("Unexpected envelope %q", session[:2])
+	}
+	if !strings.Contains(session[2], "Subject: Hello") {
+		t.Errorf("Unexpected data %q", session[2])
+	}
+}
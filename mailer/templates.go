@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// Render builds a message for to out of the named template.
+// templates/<name>.txt holds the text body and, in a "subject" block, the
+// subject. templates/<name>.html, if there is one, holds the HTML body and
+// can use the "header" and "footer" blocks of layout.html.
+func Render(to, name string, data interface{}) (Message, error) {
+	msg := Message{To: to}
+
+	text, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return msg, fmt.Errorf("unable to load mail template %s: %w", name, err)
+	}
+
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return msg, fmt.Errorf("unable to render mail template %s: %w", name, err)
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return msg, fmt.Errorf("unable to render mail template %s: %w", name, err)
+	}
+
+	msg.Subject = strings.TrimSpace(subject.String())
+	msg.Text = body.String()
+
+	if _, err := fs.Stat(templateFS, "templates/"+name+".html"); err != nil {
+		return msg, nil
+	}
+
+	html, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return msg, fmt.Errorf("unable to load mail template %s: %w", name, err)
+	}
+
+	var htmlBody bytes.Buffer
+	if err := html.ExecuteTemplate(&htmlBody, name+".html", data); err != nil {
+		return msg, fmt.Errorf("unable to render mail template %s: %w", name, err)
+	}
+	msg.HTML = htmlBody.String()
+
+	return msg, nil
+}
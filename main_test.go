@@ -32,7 +32,7 @@ func TestConnectionRedis(t *testing.T) {
 func TestCreateUserAPILegacy(t *testing.T) {
 	clearTable()
 
-	var jsonStr = []byte(`{"email":"test@email.com", "password": "123456"}`)
+	var jsonStr = []byte(`{"email":"test@email.com", "password": "correct horse 42"}`)
 	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer(jsonStr))
 	req.Header.Set("Content-Type", "application/json")
 
@@ -55,7 +55,7 @@ func TestLoginUserAPILegacy(t *testing.T) {
 	clearTable()
 
 	// This test was incorrectly named - it's actually testing signup
-	var jsonStr = []byte(`{"email":"test@email.com", "password": "123456"}`)
+	var jsonStr = []byte(`{"email":"test@email.com", "password": "correct horse 42"}`)
 	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer(jsonStr))
 	req.Header.Set("Content-Type", "application/json")
 
@@ -73,3 +73,35 @@ func TestLoginUserAPILegacy(t *testing.T) {
 		t.Errorf("Expected password to be empty. Got '%v'", m["password"])
 	}
 }
+
+func TestCreateUserAPIWeakPassword(t *testing.T) {
+	clearTable()
+
+	var jsonStr = []byte(`{"email":"test@email.com", "password": "123456"}`)
+	req, _ := http.NewRequest("POST", "/signup", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Content-Type", "application/json")
+
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	var m struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Field string `json:"field"`
+			Code  string `json:"code"`
+		} `json:"errors"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	codes := map[string]bool{}
+	for _, e := range m.Errors {
+		if e.Field != "password" {
+			t.Errorf("Expected errors about the password field. Got '%v'", e.Field)
+		}
+		codes[e.Code] = true
+	}
+
+	if !codes["too_short"] || !codes["character_classes"] {
+		t.Errorf("Expected too_short and character_classes errors. Got '%v'", m.Errors)
+	}
+}
@@ -1,5 +1,14 @@
 package models
 
 type Error struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError says what is wrong with one field of a request. Code is stable
+// for clients to match on; Message is meant for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
 	Message string `json:"message"`
 }
@@ -0,0 +1,33 @@
+package models
+
+// TOTP is a user's authenticator app enrollment. It only counts as a second
+// factor once Confirmed.
+type TOTP struct {
+	UserID      int
+	Secret      string
+	Confirmed   bool
+	LastCounter int64
+}
+
+// TOTPEnrollment is handed to the user to set up their authenticator app. URI
+// is the otpauth:// URI to show as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAChallenge is what Login returns instead of tokens when a second factor is
+// due. MFAToken goes to /login/mfa along with the code.
+type MFAChallenge struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+	ExpiresIn   int64    `json:"expires_in"`
+}
+
+// RecoveryCodes reports the user's recovery codes. Codes is only filled in
+// right after they were generated; they can't be looked up later.
+type RecoveryCodes struct {
+	Codes     []string `json:"recovery_codes,omitempty"`
+	Remaining int      `json:"remaining"`
+}
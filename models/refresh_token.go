@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	ID        int
+	FamilyID  string
+	UserID    int
+	ClientID  string
+	TokenHash string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+	// AMR is how the user authenticated when the session started, carried
+	// over to every access token of the session.
+	AMR []string
+}
@@ -1,7 +1,11 @@
 package models
 
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	EmailVerified bool   `json:"email_verified"`
+	// Roles and the Permissions they grant are loaded when issuing tokens.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"-"`
 }
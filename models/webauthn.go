@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// WebAuthnCredential is a passkey or security key registered to a user.
+// PublicKey is the COSE key the authenticator handed out at registration.
+type WebAuthnCredential struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"-"`
+	CredentialID []byte     `json:"-"`
+	Name         string     `json:"name"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"-"`
+	Transports   []string   `json:"transports"`
+	AAGUID       []byte     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnSession is the server side of a WebAuthn ceremony in progress,
+// looked up by its challenge. Ceremony is "registration", "login" or "mfa";
+// MFAChallenge is the jti of the MFA challenge an "mfa" ceremony belongs to.
+type WebAuthnSession struct {
+	Ceremony     string `json:"ceremony"`
+	UserID       int    `json:"user_id,omitempty"`
+	MFAChallenge string `json:"mfa_challenge,omitempty"`
+}
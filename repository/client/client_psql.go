@@ -0,0 +1,58 @@
+package clientRepository
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/jcprz/jwtapp/models"
+	"github.com/lib/pq"
+)
+
+type ClientRepository struct{}
+
+func (c ClientRepository) Create(db *sql.DB, client models.Client) (models.Client, error) {
+	err := db.QueryRow("insert into oauth_clients (client_id, name, redirect_uris, allowed_scopes) values ($1, $2, $3, $4) RETURNING id;",
+		client.ClientID, client.Name, pq.Array(client.RedirectURIs), pq.Array(client.AllowedScopes)).Scan(&client.ID)
+
+	if err != nil {
+		log.Printf("Error creating OAuth client: %v", err)
+	}
+
+	return client, err
+}
+
+func (c ClientRepository) GetByClientID(db *sql.DB, clientID string) (models.Client, error) {
+	var client models.Client
+
+	row := db.QueryRow("select id, client_id, name, redirect_uris, allowed_scopes from oauth_clients where client_id = $1;", clientID)
+	err := row.Scan(&client.ID, &client.ClientID, &client.Name, pq.Array(&client.RedirectURIs), pq.Array(&client.AllowedScopes))
+
+	return client, err
+}
+
+func (c ClientRepository) AddSecret(db *sql.DB, clientID string, secretHash string) error {
+	_, err := db.Exec("insert into oauth_client_secrets (client_id, secret_hash) values ($1, $2);", clientID, secretHash)
+
+	return err
+}
+
+// GetSecretHashes returns the hashes of the client's unexpired secrets. Public
+// clients have none.
+func (c ClientRepository) GetSecretHashes(db *sql.DB, clientID string) ([]string, error) {
+	rows, err := db.Query("select secret_hash from oauth_client_secrets where client_id = $1 and (expires_at is null or expires_at > now());", clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
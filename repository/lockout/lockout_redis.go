@@ -0,0 +1,82 @@
+package lockoutRepository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+type LockoutRepository struct{}
+
+// Reasons a subject can be blocked from logging in.
+const (
+	BlockDelay   = "delay"
+	BlockLockout = "lockout"
+)
+
+func loginFailuresKey(subject string) string {
+	return fmt.Sprintf("login_failures:%s", subject)
+}
+
+func loginBlockedKey(subject string) string {
+	return fmt.Sprintf("login_blocked:%s", subject)
+}
+
+// RecordFailure counts a failed login of subject, e.g. "account:<email>" or
+// "ip:<address>", and returns the failures so far. The count expires window
+// after the latest failure.
+func (l LockoutRepository) RecordFailure(rds *redis.Client, subject string, window time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+
+	_, err := rds.TxPipelined(func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(loginFailuresKey(subject))
+		pipe.Expire(loginFailuresKey(subject), window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// Block stops subject from logging in for d. reason is BlockDelay or
+// BlockLockout. A shorter block never replaces a longer one still running.
+func (l LockoutRepository) Block(rds *redis.Client, subject, reason string, d time.Duration) error {
+	ttl, err := rds.PTTL(loginBlockedKey(subject)).Result()
+	if err != nil {
+		return err
+	}
+	if ttl >= d {
+		return nil
+	}
+
+	return rds.Set(loginBlockedKey(subject), reason, d).Err()
+}
+
+// Blocked returns why and for how much longer subject is blocked, or an empty
+// reason when it isn't.
+func (l LockoutRepository) Blocked(rds *redis.Client, subject string) (string, time.Duration, error) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+
+	_, err := rds.Pipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(loginBlockedKey(subject))
+		pttl = pipe.PTTL(loginBlockedKey(subject))
+		return nil
+	})
+	if err == redis.Nil {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return get.Val(), pttl.Val(), nil
+}
+
+// Reset forgets the failures of subject and lifts any block.
+func (l LockoutRepository) Reset(redis *redis.Client, subject string) error {
+	return redis.Del(loginFailuresKey(subject), loginBlockedKey(subject)).Err()
+}
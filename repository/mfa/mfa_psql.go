@@ -0,0 +1,130 @@
+package mfaRepository
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+type MFARepository struct{}
+
+// SaveTOTPSecret starts a TOTP enrollment, replacing an unconfirmed one. It
+// reports false when the user already has a confirmed authenticator, which
+// has to be removed first.
+func (m MFARepository) SaveTOTPSecret(db *sql.DB, userID int, secret string) (bool, error) {
+	result, err := db.Exec("insert into user_totp (user_id, secret) values ($1, $2) on conflict (user_id) do update set secret = excluded.secret, last_counter = 0, created_at = now() where user_totp.confirmed_at is null;", userID, secret)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (m MFARepository) GetTOTP(db *sql.DB, userID int) (models.TOTP, error) {
+	var totp models.TOTP
+
+	row := db.QueryRow("select user_id, secret, confirmed_at is not null, last_counter from user_totp where user_id = $1;", userID)
+	err := row.Scan(&totp.UserID, &totp.Secret, &totp.Confirmed, &totp.LastCounter)
+
+	return totp, err
+}
+
+// HasTOTP reports whether the user has a confirmed authenticator.
+func (m MFARepository) HasTOTP(db *sql.DB, userID int) (bool, error) {
+	var exists bool
+
+	err := db.QueryRow("select exists (select 1 from user_totp where user_id = $1 and confirmed_at is not null);", userID).Scan(&exists)
+
+	return exists, err
+}
+
+// UseTOTPCounter records the time step of an accepted code. It reports false
+// when that step, or a later one, was used already, which means the code is
+// being replayed.
+func (m MFARepository) UseTOTPCounter(db *sql.DB, userID int, counter int64) (bool, error) {
+	result, err := db.Exec("update user_totp set last_counter = $2 where user_id = $1 and last_counter < $2;", userID, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (m MFARepository) ConfirmTOTP(db *sql.DB, userID int) error {
+	log.Printf("Enabling TOTP for user %d", userID)
+	_, err := db.Exec("update user_totp set confirmed_at = now() where user_id = $1;", userID)
+
+	return err
+}
+
+func (m MFARepository) DeleteTOTP(db *sql.DB, userID int) error {
+	log.Printf("Disabling TOTP for user %d", userID)
+	_, err := db.Exec("delete from user_totp where user_id = $1;", userID)
+
+	return err
+}
+
+// ReplaceRecoveryCodes throws away the user's recovery codes, used or not,
+// and stores the given hashes as the new set.
+func (m MFARepository) ReplaceRecoveryCodes(db *sql.DB, userID int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from mfa_recovery_codes where user_id = $1;", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("insert into mfa_recovery_codes (user_id, code_hash) values ($1, $2);", userID, hash); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Generated %d recovery codes for user %d", len(codeHashes), userID)
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used and
+// reports whether there was one.
+func (m MFARepository) UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+	result, err := db.Exec("update mfa_recovery_codes set used_at = now() where user_id = $1 and code_hash = $2 and used_at is null;", userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left.
+func (m MFARepository) CountRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var count int
+
+	err := db.QueryRow("select count(*) from mfa_recovery_codes where user_id = $1 and used_at is null;", userID).Scan(&count)
+
+	return count, err
+}
+
+func (m MFARepository) DeleteRecoveryCodes(db *sql.DB, userID int) error {
+	_, err := db.Exec("delete from mfa_recovery_codes where user_id = $1;", userID)
+
+	return err
+}
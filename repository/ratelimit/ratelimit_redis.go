@@ -0,0 +1,75 @@
+package ratelimitRepository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+type RateLimitRepository struct{}
+
+// Window is the state of a sliding window after a hit: whether the request
+// was let through and the counts of the previous and current fixed windows.
+type Window struct {
+	Allowed  bool
+	Previous int64
+	Current  int64
+	Elapsed  time.Duration
+}
+
+// hitScript counts a request in the current fixed window unless the sliding
+// window estimate is already at the limit. Denied requests aren't counted,
+// so a client hammering the API gets back in once its earlier requests have
+// slid out.
+//
+// KEYS: current window, previous window.
+// ARGV: limit, window in ms, ms elapsed in the current window.
+var hitScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+local window = tonumber(ARGV[2])
+local used = previous * (window - tonumber(ARGV[3])) / window + current
+
+if used >= tonumber(ARGV[1]) then
+	return {0, previous, current}
+end
+
+redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], window * 2)
+return {1, previous, current + 1}
+`)
+
+func rateLimitKey(key string, window int64) string {
+	return fmt.Sprintf("rate_limit:%s:%d", key, window)
+}
+
+// Hit counts a request against key, e.g. "login:ip:<address>", which may
+// see limit requests in any sliding window of the given length.
+func (r RateLimitRepository) Hit(rds *redis.Client, key string, limit int64, window time.Duration, now time.Time) (Window, error) {
+	windowMs := window.Milliseconds()
+	index := now.UnixMilli() / windowMs
+	elapsedMs := now.UnixMilli() % windowMs
+
+	res, err := hitScript.Run(rds, []string{rateLimitKey(key, index), rateLimitKey(key, index-1)},
+		limit, windowMs, elapsedMs).Result()
+	if err != nil {
+		return Window{}, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 3 {
+		return Window{}, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+
+	allowed, _ := values[0].(int64)
+	previous, _ := values[1].(int64)
+	current, _ := values[2].(int64)
+
+	return Window{
+		Allowed:  allowed == 1,
+		Previous: previous,
+		Current:  current,
+		Elapsed:  time.Duration(elapsedMs) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,77 @@
+package roleRepository
+
+import (
+	"database/sql"
+)
+
+type RoleRepository struct{}
+
+func queryNames(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// UserRoles returns the names of the user's roles.
+func (r RoleRepository) UserRoles(db *sql.DB, userID int) ([]string, error) {
+	return queryNames(db, "select r.name from roles r join user_roles ur on ur.role_id = r.id where ur.user_id = $1 order by r.name;", userID)
+}
+
+// UserPermissions returns the names of the permissions the user's roles
+// grant between them.
+func (r RoleRepository) UserPermissions(db *sql.DB, userID int) ([]string, error) {
+	return queryNames(db, "select distinct p.name from permissions p join role_permissions rp on rp.permission_id = p.id join user_roles ur on ur.role_id = rp.role_id where ur.user_id = $1 order by p.name;", userID)
+}
+
+// RoleExists reports whether a role of that name exists.
+func (r RoleRepository) RoleExists(db *sql.DB, role string) (bool, error) {
+	var exists bool
+
+	err := db.QueryRow("select exists (select 1 from roles where name = $1);", role).Scan(&exists)
+
+	return exists, err
+}
+
+// Assign gives the user the role and reports whether they didn't have it
+// yet.
+func (r RoleRepository) Assign(db *sql.DB, userID int, role string) (bool, error) {
+	result, err := db.Exec("insert into user_roles (user_id, role_id) select $1, id from roles where name = $2 on conflict do nothing;", userID, role)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// Revoke takes the role from the user and reports whether they had it.
+func (r RoleRepository) Revoke(db *sql.DB, userID int, role string) (bool, error) {
+	result, err := db.Exec("delete from user_roles where user_id = $1 and role_id = (select id from roles where name = $2);", userID, role)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
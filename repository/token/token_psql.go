@@ -0,0 +1,99 @@
+package tokenRepository
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jcprz/jwtapp/models"
+	"github.com/lib/pq"
+)
+
+type TokenRepository struct{}
+
+func (t TokenRepository) CreateRefreshToken(db *sql.DB, token models.RefreshToken) (models.RefreshToken, error) {
+	err := db.QueryRow("insert into refresh_tokens (family_id, user_id, client_id, token_hash, expires_at, amr) values ($1, $2, nullif($3, ''), $4, $5, $6) RETURNING id;",
+		token.FamilyID, token.UserID, token.ClientID, token.TokenHash, token.ExpiresAt, pq.Array(token.AMR)).Scan(&token.ID)
+
+	if err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		return token, err
+	}
+
+	return token, nil
+}
+
+func (t TokenRepository) FindRefreshToken(db *sql.DB, tokenHash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+
+	row := db.QueryRow("select id, family_id, user_id, coalesce(client_id, ''), token_hash, expires_at, used_at is not null, revoked_at is not null, amr from refresh_tokens where token_hash = $1;", tokenHash)
+	err := row.Scan(&token.ID, &token.FamilyID, &token.UserID, &token.ClientID, &token.TokenHash, &token.ExpiresAt, &token.Used, &token.Revoked, pq.Array(&token.AMR))
+
+	return token, err
+}
+
+// MarkRefreshTokenUsed flags the token as rotated. It reports false when the
+// token had already been used or revoked, which lets two concurrent refreshes
+// with the same token be told apart from a legitimate one.
+func (t TokenRepository) MarkRefreshTokenUsed(db *sql.DB, id int) (bool, error) {
+	result, err := db.Exec("update refresh_tokens set used_at = now() where id = $1 and used_at is null and revoked_at is null;", id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (t TokenRepository) RevokeTokenFamily(db *sql.DB, familyID string) error {
+	log.Printf("Revoking refresh token family %s", familyID)
+	_, err := db.Exec("update refresh_tokens set revoked_at = now() where family_id = $1 and revoked_at is null;", familyID)
+
+	return err
+}
+
+func (t TokenRepository) RevokeUserRefreshTokens(db *sql.DB, userID int) error {
+	log.Printf("Revoking all refresh tokens of user %d", userID)
+	_, err := db.Exec("update refresh_tokens set revoked_at = now() where user_id = $1 and revoked_at is null;", userID)
+
+	return err
+}
+
+func (t TokenRepository) CreatePasswordResetToken(db *sql.DB, userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := db.Exec("insert into password_reset_tokens (user_id, token_hash, expires_at) values ($1, $2, $3);", userID, tokenHash, expiresAt)
+
+	return err
+}
+
+// PasswordResetTokenUser returns the user an unused, unexpired reset token
+// belongs to, without using it up.
+func (t TokenRepository) PasswordResetTokenUser(db *sql.DB, tokenHash string) (int, error) {
+	var userID int
+
+	err := db.QueryRow("select user_id from password_reset_tokens where token_hash = $1 and used_at is null and expires_at > now();", tokenHash).Scan(&userID)
+
+	return userID, err
+}
+
+// ConsumePasswordResetToken marks an unused, unexpired reset token as used and
+// returns the user it belongs to. Doing both in one statement means a token
+// can't be redeemed twice, even concurrently.
+func (t TokenRepository) ConsumePasswordResetToken(db *sql.DB, tokenHash string) (int, error) {
+	var userID int
+
+	err := db.QueryRow("update password_reset_tokens set used_at = now() where token_hash = $1 and used_at is null and expires_at > now() RETURNING user_id;", tokenHash).Scan(&userID)
+
+	return userID, err
+}
+
+// DeletePasswordResetTokens throws away every reset token of the user, used
+// or not.
+func (t TokenRepository) DeletePasswordResetTokens(db *sql.DB, userID int) error {
+	_, err := db.Exec("delete from password_reset_tokens where user_id = $1;", userID)
+
+	return err
+}
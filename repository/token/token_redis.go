@@ -0,0 +1,220 @@
+package tokenRepository
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jcprz/jwtapp/models"
+)
+
+func denylistKey(jti string) string {
+	return fmt.Sprintf("denylist:%s", jti)
+}
+
+func revokedBeforeKey(sub string) string {
+	return fmt.Sprintf("revoked_before:%s", sub)
+}
+
+// DenyToken puts an access token on the denylist. The entry only has to live
+// as long as the token itself would have, so ttl should be its remaining
+// lifetime.
+func (t TokenRepository) DenyToken(redis *redis.Client, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return redis.Set(denylistKey(jti), 1, ttl).Err()
+}
+
+func (t TokenRepository) IsTokenDenied(redis *redis.Client, jti string) (bool, error) {
+	n, err := redis.Exists(denylistKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// RevokeTokensIssuedBefore invalidates every access token of sub issued before
+// the given time. ttl should be at least the access token lifetime, after
+// which any such token has expired anyway.
+func (t TokenRepository) RevokeTokensIssuedBefore(redis *redis.Client, sub string, before time.Time, ttl time.Duration) error {
+	return redis.Set(revokedBeforeKey(sub), before.Unix(), ttl).Err()
+}
+
+// TokensRevokedBefore returns the cutoff set by RevokeTokensIssuedBefore, or
+// the zero time when there is none.
+func (t TokenRepository) TokensRevokedBefore(rds *redis.Client, sub string) (time.Time, error) {
+	value, err := rds.Get(revokedBeforeKey(sub)).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(unix, 0), nil
+}
+
+func authorizationCodeKey(codeHash string) string {
+	return fmt.Sprintf("authcode:%s", codeHash)
+}
+
+func (t TokenRepository) SaveAuthorizationCode(redis *redis.Client, codeHash string, code models.AuthorizationCode, ttl time.Duration) error {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return err
+	}
+
+	return redis.Set(authorizationCodeKey(codeHash), data, ttl).Err()
+}
+
+// ConsumeAuthorizationCode returns the authorization code and deletes it in
+// the same transaction, so a code can only ever be redeemed once.
+func (t TokenRepository) ConsumeAuthorizationCode(rds *redis.Client, codeHash string) (models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	var get *redis.StringCmd
+
+	_, err := rds.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(authorizationCodeKey(codeHash))
+		pipe.Del(authorizationCodeKey(codeHash))
+		return nil
+	})
+	if err != nil {
+		return code, err
+	}
+
+	data, err := get.Bytes()
+	if err != nil {
+		return code, err
+	}
+
+	err = json.Unmarshal(data, &code)
+	return code, err
+}
+
+func emailVerificationKey(jti string) string {
+	return fmt.Sprintf("email_verification:%s", jti)
+}
+
+// SaveEmailVerification records an outstanding verification token, which is
+// what makes it usable exactly once.
+func (t TokenRepository) SaveEmailVerification(redis *redis.Client, jti string, ttl time.Duration) error {
+	return redis.Set(emailVerificationKey(jti), 1, ttl).Err()
+}
+
+// ConsumeEmailVerification removes an outstanding verification token and
+// reports whether it was still there.
+func (t TokenRepository) ConsumeEmailVerification(redis *redis.Client, jti string) (bool, error) {
+	n, err := redis.Del(emailVerificationKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func mfaChallengeKey(jti string) string {
+	return fmt.Sprintf("mfa_challenge:%s", jti)
+}
+
+// SaveMFAChallenge records an outstanding MFA challenge together with the
+// number of attempts it allows.
+func (t TokenRepository) SaveMFAChallenge(redis *redis.Client, jti string, attempts int, ttl time.Duration) error {
+	return redis.Set(mfaChallengeKey(jti), attempts, ttl).Err()
+}
+
+// UseMFAChallengeAttempt takes one attempt off the challenge and reports
+// whether there was one left. Challenges that are used up, already completed
+// or never existed have none.
+func (t TokenRepository) UseMFAChallengeAttempt(redis *redis.Client, jti string) (bool, error) {
+	left, err := redis.Decr(mfaChallengeKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if left < 0 {
+		redis.Del(mfaChallengeKey(jti))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// CompleteMFAChallenge removes the challenge and reports whether it was still
+// there, so only one request can complete it.
+func (t TokenRepository) CompleteMFAChallenge(redis *redis.Client, jti string) (bool, error) {
+	n, err := redis.Del(mfaChallengeKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func webAuthnSessionKey(challenge string) string {
+	return fmt.Sprintf("webauthn:%s", challenge)
+}
+
+// SaveWebAuthnSession records a WebAuthn ceremony under its challenge.
+func (t TokenRepository) SaveWebAuthnSession(redis *redis.Client, challenge string, session models.WebAuthnSession, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return redis.Set(webAuthnSessionKey(challenge), data, ttl).Err()
+}
+
+// ConsumeWebAuthnSession returns the ceremony started with the challenge and
+// deletes it in the same transaction, so each challenge is answered once.
+func (t TokenRepository) ConsumeWebAuthnSession(rds *redis.Client, challenge string) (models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	var get *redis.StringCmd
+
+	_, err := rds.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(webAuthnSessionKey(challenge))
+		pipe.Del(webAuthnSessionKey(challenge))
+		return nil
+	})
+	if err != nil {
+		return session, err
+	}
+
+	data, err := get.Bytes()
+	if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
+
+func magicLinkKey(jti string) string {
+	return fmt.Sprintf("magic_link:%s", jti)
+}
+
+// SaveMagicLink records an outstanding login link, which is what makes it
+// usable exactly once.
+func (t TokenRepository) SaveMagicLink(redis *redis.Client, jti string, ttl time.Duration) error {
+	return redis.Set(magicLinkKey(jti), 1, ttl).Err()
+}
+
+// ConsumeMagicLink removes an outstanding login link and reports whether it
+// was still there.
+func (t TokenRepository) ConsumeMagicLink(redis *redis.Client, jti string) (bool, error) {
+	n, err := redis.Del(magicLinkKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
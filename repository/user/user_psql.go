@@ -35,8 +35,8 @@ func (u UserRepository) Login(db *sql.DB, redis *redis.Client, user models.User)
 	if err != nil || len(result) == 0 {
 		log.Printf("Unable to find %s on redis cache", user.Email)
 		log.Println("Executing query to the database")
-		row := db.QueryRow("select * from users where email = $1;", user.Email)
-		err := row.Scan(&user.ID, &user.Email, &user.Password)
+		row := db.QueryRow("select id, email, password, email_verified_at is not null from users where email = $1;", user.Email)
+		err := row.Scan(&user.ID, &user.Email, &user.Password, &user.EmailVerified)
 
 		if err != nil {
 			return user, err
@@ -69,8 +69,8 @@ func (u UserRepository) Login(db *sql.DB, redis *redis.Client, user models.User)
 	user.Email = result["email"]
 
 	// Always fetch password from database (never cache it)
-	row := db.QueryRow("select password from users where email = $1;", user.Email)
-	err = row.Scan(&user.Password)
+	row := db.QueryRow("select password, email_verified_at is not null from users where email = $1;", user.Email)
+	err = row.Scan(&user.Password, &user.EmailVerified)
 
 	if err != nil {
 		return user, err
@@ -79,7 +79,7 @@ func (u UserRepository) Login(db *sql.DB, redis *redis.Client, user models.User)
 	return user, nil
 }
 
-func (u UserRepository) Delete(db *sql.DB, redis *redis.Client, user models.User) error {
+func (u UserRepository) Delete(db *sql.DB, redis *redis.Client, user models.User) (models.User, error) {
 
 	log.Printf("Deleting user: %s from the database", user.Email)
 	delUser := db.QueryRow("delete from users where email = $1 RETURNING id;", user.Email)
@@ -87,7 +87,7 @@ func (u UserRepository) Delete(db *sql.DB, redis *redis.Client, user models.User
 
 	if err != nil {
 		log.Printf("User %s not found on the database\n", user.Email)
-		return err
+		return user, err
 	}
 
 	log.Printf("User %s has been deleted from the database\n", user.Email)
@@ -95,5 +95,103 @@ func (u UserRepository) Delete(db *sql.DB, redis *redis.Client, user models.User
 	// Delete user from redis too
 	redis.Del(user.Email)
 	log.Println("User has been deleted from redis cache")
+	return user, nil
+}
+
+func (u UserRepository) GetByID(db *sql.DB, id int) (models.User, error) {
+	var user models.User
+
+	row := db.QueryRow("select id, email, email_verified_at is not null from users where id = $1;", id)
+	err := row.Scan(&user.ID, &user.Email, &user.EmailVerified)
+
+	return user, err
+}
+
+// MarkEmailVerified flags the user's email address as verified, provided it
+// is still the address the verification was sent to. It reports whether the
+// user was updated.
+func (u UserRepository) MarkEmailVerified(db *sql.DB, id int, email string) (bool, error) {
+	result, err := db.Exec("update users set email_verified_at = coalesce(email_verified_at, now()) where id = $1 and email = $2;", id, email)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (u UserRepository) UpdatePassword(db *sql.DB, id int, password string) error {
+	result, err := db.Exec("update users set password = $1 where id = $2;", password, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
+
+// RehashPassword replaces the user's password hash with a new hash of the
+// same password, provided it is still oldHash. It reports false when the
+// password changed in the meantime, which must not be undone.
+func (u UserRepository) RehashPassword(db *sql.DB, id int, oldHash, newHash string) (bool, error) {
+	result, err := db.Exec("update users set password = $1 where id = $2 and password = $3;", newHash, id, oldHash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (u UserRepository) GetPassword(db *sql.DB, id int) (string, error) {
+	var password string
+
+	err := db.QueryRow("select password from users where id = $1;", id).Scan(&password)
+
+	return password, err
+}
+
+// EmailExists reports whether any account uses the address.
+func (u UserRepository) EmailExists(db *sql.DB, email string) (bool, error) {
+	var exists bool
+
+	err := db.QueryRow("select exists (select 1 from users where email = $1);", email).Scan(&exists)
+
+	return exists, err
+}
+
+// UpdateEmail moves the user to a new, not yet verified, email address and
+// re-keys the Redis cache entry to match.
+func (u UserRepository) UpdateEmail(db *sql.DB, redis *redis.Client, user models.User, email string) (models.User, error) {
+	_, err := db.Exec("update users set email = $1, email_verified_at = null where id = $2;", email, user.ID)
+	if err != nil {
+		return user, err
+	}
+
+	log.Printf("User %d changed email from %s to %s", user.ID, user.Email, email)
+
+	redis.Del(user.Email)
+
+	user.Email = email
+	user.EmailVerified = false
+
+	redis.HSet(user.Email, "id", user.ID)
+	redis.HSet(user.Email, "email", user.Email)
+
+	return user, nil
+}
@@ -0,0 +1,103 @@
+package webauthnRepository
+
+import (
+	"database/sql"
+
+	"github.com/jcprz/jwtapp/models"
+	"github.com/lib/pq"
+)
+
+type WebAuthnRepository struct{}
+
+const credentialColumns = "id, user_id, credential_id, name, public_key, sign_count, transports, aaguid, created_at, last_used_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCredential(row scanner) (models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	var signCount int64
+
+	err := row.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.Name, &credential.PublicKey,
+		&signCount, pq.Array(&credential.Transports), &credential.AAGUID, &credential.CreatedAt, &credential.LastUsedAt)
+	credential.SignCount = uint32(signCount)
+
+	return credential, err
+}
+
+// Create stores a newly registered credential. A credential ID that is
+// already registered, to this or another user, gives sql.ErrNoRows.
+func (w WebAuthnRepository) Create(db *sql.DB, credential models.WebAuthnCredential) (models.WebAuthnCredential, error) {
+	err := db.QueryRow("insert into webauthn_credentials (user_id, credential_id, name, public_key, sign_count, transports, aaguid) values ($1, $2, $3, $4, $5, $6, $7) on conflict (credential_id) do nothing returning id, created_at;",
+		credential.UserID, credential.CredentialID, credential.Name, credential.PublicKey, int64(credential.SignCount), pq.Array(credential.Transports), credential.AAGUID).
+		Scan(&credential.ID, &credential.CreatedAt)
+
+	return credential, err
+}
+
+func (w WebAuthnRepository) GetByCredentialID(db *sql.DB, credentialID []byte) (models.WebAuthnCredential, error) {
+	return scanCredential(db.QueryRow("select "+credentialColumns+" from webauthn_credentials where credential_id = $1;", credentialID))
+}
+
+func (w WebAuthnRepository) ListByUser(db *sql.DB, userID int) ([]models.WebAuthnCredential, error) {
+	rows, err := db.Query("select "+credentialColumns+" from webauthn_credentials where user_id = $1 order by id;", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// HasCredentials reports whether the user registered any credential.
+func (w WebAuthnRepository) HasCredentials(db *sql.DB, userID int) (bool, error) {
+	var exists bool
+
+	err := db.QueryRow("select exists (select 1 from webauthn_credentials where user_id = $1);", userID).Scan(&exists)
+
+	return exists, err
+}
+
+// UseCredential records a successful assertion. It reports false when the
+// stored signature counter moved on in the meantime, i.e. another assertion
+// with the same or a later counter got there first.
+func (w WebAuthnRepository) UseCredential(db *sql.DB, credential models.WebAuthnCredential, signCount uint32) (bool, error) {
+	result, err := db.Exec("update webauthn_credentials set sign_count = $3, last_used_at = now() where id = $1 and sign_count = $2;",
+		credential.ID, int64(credential.SignCount), int64(signCount))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// Delete removes one of the user's credentials and reports whether it
+// existed.
+func (w WebAuthnRepository) Delete(db *sql.DB, userID int, id int) (bool, error) {
+	result, err := db.Exec("delete from webauthn_credentials where id = $1 and user_id = $2;", id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
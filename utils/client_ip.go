@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the IP address of the client that sent r. Behind proxies
+// or a load balancer, TRUSTED_PROXY_HOPS says how many of them append to
+// X-Forwarded-For: the entry that many places from the right is the client
+// as the outermost trusted proxy saw it. Anything further left was sent by
+// the client and can't be trusted.
+func ClientIP(r *http.Request) string {
+	if hops := intFromEnv("TRUSTED_PROXY_HOPS", 0); hops > 0 {
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+
+		if int64(len(forwarded)) >= hops {
+			if ip := net.ParseIP(forwarded[int64(len(forwarded))-hops]); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
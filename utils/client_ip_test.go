@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer os.Unsetenv("TRUSTED_PROXY_HOPS")
+
+	tests := []struct {
+		hops      string
+		forwarded string
+		expected  string
+	}{
+		{"", "198.51.100.1", "192.0.2.1"},
+		{"1", "198.51.100.1", "198.51.100.1"},
+		{"1", "203.0.113.9, 198.51.100.1", "198.51.100.1"},
+		{"2", "203.0.113.9, 198.51.100.1, 10.0.0.1", "198.51.100.1"},
+		{"2", "198.51.100.1", "192.0.2.1"},
+		{"1", "not an ip", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("TRUSTED_PROXY_HOPS", tt.hops)
+
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", tt.forwarded)
+
+		if got := ClientIP(r); got != tt.expected {
+			t.Errorf("ClientIP() with %q hops and X-Forwarded-For %q = %q, expected %q", tt.hops, tt.forwarded, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+// KeyRing holds every key we currently sign or verify with. Tokens are signed
+// with the active key, the most recently activated one that is not retired,
+// and verified with whichever non-retired key their kid points to. Keys are
+// published before they activate so verifiers have picked them up by the time
+// the first token signed with them shows up.
+type KeyRing struct {
+	keys []*SigningKey
+}
+
+// NewKeyRing builds a key ring, rejecting duplicate key IDs.
+func NewKeyRing(keys ...*SigningKey) (*KeyRing, error) {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate key ID %q in key ring", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	sorted := append([]*SigningKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivateAt.Before(sorted[j].ActivateAt)
+	})
+
+	return &KeyRing{keys: sorted}, nil
+}
+
+func (k *SigningKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+func (k *SigningKey) active(now time.Time) bool {
+	return !now.Before(k.ActivateAt) && !k.retired(now)
+}
+
+// SigningKey returns the key new tokens should be signed with.
+func (r *KeyRing) SigningKey(now time.Time) (*SigningKey, error) {
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if r.keys[i].active(now) {
+			return r.keys[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no active signing key")
+}
+
+// VerificationKey returns the non-retired key with the given ID.
+func (r *KeyRing) VerificationKey(kid string, now time.Time) (*SigningKey, error) {
+	for _, key := range r.keys {
+		if key.ID == kid && !key.retired(now) {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// PublicKeys returns the JWKs of all non-retired asymmetric keys, including
+// the ones scheduled to activate later.
+func (r *KeyRing) PublicKeys(now time.Time) ([]models.JWK, error) {
+	jwks := []models.JWK{}
+
+	for _, key := range r.keys {
+		if key.retired(now) {
+			continue
+		}
+		if _, ok := key.Public.([]byte); ok {
+			continue
+		}
+
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks, nil
+}
+
+type keyRingConfig struct {
+	Keys []keyConfig `json:"keys"`
+}
+
+type keyConfig struct {
+	ID                  string    `json:"kid"`
+	Alg                 string    `json:"alg"`
+	PrivateKeyFile      string    `json:"private_key_file"`
+	PrivateKeySecretArn string    `json:"private_key_secret_arn"`
+	SecretEnv           string    `json:"secret_env"`
+	SecretArn           string    `json:"secret_arn"`
+	SecretName          string    `json:"secret_name"`
+	ActivateAt          time.Time `json:"activate_at"`
+	RetireAt            time.Time `json:"retire_at"`
+}
+
+var (
+	keyRingMu      sync.Mutex
+	keyRingPath    string
+	keyRingModTime time.Time
+	keyRing        *KeyRing
+)
+
+// LoadKeyRing returns the key ring described by the JSON file in
+// JWT_KEYRING_FILE, reloading it whenever the file changes. Without it the
+// ring holds the single key configured through JWT_SIGNING_ALG.
+func LoadKeyRing() (*KeyRing, error) {
+	path := os.Getenv("JWT_KEYRING_FILE")
+	if path == "" {
+		key, err := LoadSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyRing(key)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key ring file: %w", err)
+	}
+
+	keyRingMu.Lock()
+	defer keyRingMu.Unlock()
+
+	if keyRing != nil && keyRingPath == path && keyRingModTime.Equal(info.ModTime()) {
+		return keyRing, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key ring file: %w", err)
+	}
+
+	ring, err := ParseKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded key ring from %s with %d keys", path, len(ring.keys))
+	keyRing, keyRingPath, keyRingModTime = ring, path, info.ModTime()
+
+	return ring, nil
+}
+
+// ParseKeyRing builds a key ring out of its JSON description, e.g.
+//
+//	{"keys": [
+//	  {"kid": "2024-01", "alg": "ES256", "private_key_file": "/keys/2024-01.pem",
+//	   "retire_at": "2024-07-01T00:00:00Z"},
+//	  {"kid": "2024-06", "alg": "ES256", "private_key_file": "/keys/2024-06.pem",
+//	   "activate_at": "2024-06-01T00:00:00Z"}
+//	]}
+//
+// HS256 keys take their secret from the environment variable named in
+// secret_env, the Secrets Manager secret in secret_arn or the secret called
+// secret_name in the configured SecretProvider.
+func ParseKeyRing(data []byte) (*KeyRing, error) {
+	var config keyRingConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse key ring: %w", err)
+	}
+
+	var keys []*SigningKey
+	for _, kc := range config.Keys {
+		key, err := kc.load()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	ring, err := NewKeyRing(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	ring.warnOnShortOverlap()
+	return ring, nil
+}
+
+func (kc keyConfig) load() (*SigningKey, error) {
+	if kc.ID == "" {
+		return nil, fmt.Errorf("kid is required")
+	}
+
+	var key *SigningKey
+	var err error
+
+	switch {
+	case kc.Alg == jwt.SigningMethodHS256.Alg():
+		secret := os.Getenv(kc.SecretEnv)
+		if kc.SecretName != "" {
+			secret, err = getSecret(kc.SecretName)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if kc.SecretArn != "" {
+			secret, err = GetSecretValue(kc.SecretArn)
+			if err != nil {
+				return nil, err
+			}
+		}
+		key, err = newHMACSigningKey(kc.ID, secret)
+	case kc.PrivateKeyFile != "":
+		var pemData []byte
+		pemData, err = os.ReadFile(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key file: %w", err)
+		}
+		key, err = ParseSigningKey(kc.Alg, kc.ID, pemData)
+	case kc.PrivateKeySecretArn != "":
+		var secret string
+		secret, err = GetSecretValue(kc.PrivateKeySecretArn)
+		if err != nil {
+			return nil, err
+		}
+		key, err = ParseSigningKey(kc.Alg, kc.ID, []byte(secret))
+	default:
+		return nil, fmt.Errorf("%s requires private_key_file or private_key_secret_arn", kc.Alg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !kc.RetireAt.IsZero() && !kc.RetireAt.After(kc.ActivateAt) {
+		return nil, fmt.Errorf("retire_at must be after activate_at")
+	}
+
+	key.ActivateAt, key.RetireAt = kc.ActivateAt, kc.RetireAt
+	return key, nil
+}
+
+// warnOnShortOverlap logs keys that retire less than an access token lifetime
+// after their successor activates, which cuts off tokens that were still
+// valid.
+func (r *KeyRing) warnOnShortOverlap() {
+	for i, key := range r.keys {
+		if key.RetireAt.IsZero() || i == len(r.keys)-1 {
+			continue
+		}
+
+		next := r.keys[i+1]
+		if key.RetireAt.Sub(next.ActivateAt) < AccessTokenTTL() {
+			log.Printf("Key %s retires less than %s after key %s activates, tokens signed with it may be rejected early", key.ID, AccessTokenTTL(), next.ID)
+		}
+	}
+}
+
+// SignToken signs claims with the active key of the key ring, setting the
+// kid header so verifiers can pick the right key.
+func SignToken(claims jwt.Claims) (string, error) {
+	return signTypedToken(claims, "")
+}
+
+// signTypedToken is SignToken for tokens that are not access tokens. typ ends
+// up in the header so such a token can never be passed off as an access
+// token, see ParseToken.
+func signTypedToken(claims jwt.Claims, typ string) (string, error) {
+	ring, err := LoadKeyRing()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ring.SigningKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+
+	return token.SignedString(key.Private)
+}
+
+// JWKS returns the public keys tokens can be verified with.
+func JWKS() (models.JWKSet, error) {
+	ring, err := LoadKeyRing()
+	if err != nil {
+		return models.JWKSet{Keys: []models.JWK{}}, err
+	}
+
+	keys, err := ring.PublicKeys(time.Now())
+	if err != nil {
+		return models.JWKSet{Keys: []models.JWK{}}, err
+	}
+
+	return models.JWKSet{Keys: keys}, nil
+}
+
+// verificationKey is the jwt.Keyfunc for tokens we issued. Tokens minted
+// before key IDs were introduced carry no kid and are checked against the
+// active key.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	ring, err := LoadKeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var key *SigningKey
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, err = ring.VerificationKey(kid, now)
+	} else {
+		key, err = ring.SigningKey(now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
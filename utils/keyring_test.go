@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestKeyRingSchedule(t *testing.T) {
+	t.Setenv("OLD_SECRET", "old-secret")
+	t.Setenv("NEW_SECRET", "new-secret")
+
+	ring, err := ParseKeyRing([]byte(`{"keys": [
+		{"kid": "old", "alg": "HS256", "secret_env": "OLD_SECRET", "retire_at": "2024-02-01T00:00:00Z"},
+		{"kid": "new", "alg": "HS256", "secret_env": "NEW_SECRET", "activate_at": "2024-01-01T00:00:00Z"}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParseKeyRing() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		now        string
+		signingKey string
+		verifies   map[string]bool
+	}{
+		{name: "Before rotation", now: "2023-12-15T00:00:00Z", signingKey: "old", verifies: map[string]bool{"old": true, "new": true}},
+		{name: "Overlap", now: "2024-01-15T00:00:00Z", signingKey: "new", verifies: map[string]bool{"old": true, "new": true}},
+		{name: "After retirement", now: "2024-02-15T00:00:00Z", signingKey: "new", verifies: map[string]bool{"old": false, "new": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, tt.now)
+
+			key, err := ring.SigningKey(now)
+			if err != nil {
+				t.Fatalf("SigningKey() returned error: %v", err)
+			}
+			if key.ID != tt.signingKey {
+				t.Errorf("Expected signing key %s, got %s", tt.signingKey, key.ID)
+			}
+
+			for kid, expected := range tt.verifies {
+				_, err := ring.VerificationKey(kid, now)
+				if (err == nil) != expected {
+					t.Errorf("VerificationKey(%s) error = %v, expected usable = %v", kid, err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyRingValidation(t *testing.T) {
+	t.Setenv("RING_SECRET", "secret")
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "Duplicate kid", config: `{"keys": [{"kid": "a", "alg": "HS256", "secret_env": "RING_SECRET"}, {"kid": "a", "alg": "HS256", "secret_env": "RING_SECRET"}]}`},
+		{name: "Missing kid", config: `{"keys": [{"alg": "HS256", "secret_env": "RING_SECRET"}]}`},
+		{name: "Missing secret", config: `{"keys": [{"kid": "a", "alg": "HS256", "secret_env": "UNSET_SECRET"}]}`},
+		{name: "Retired before activation", config: `{"keys": [{"kid": "a", "alg": "HS256", "secret_env": "RING_SECRET", "activate_at": "2024-02-01T00:00:00Z", "retire_at": "2024-01-01T00:00:00Z"}]}`},
+		{name: "Missing private key", config: `{"keys": [{"kid": "a", "alg": "ES256"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeyRing([]byte(tt.config)); err == nil {
+				t.Error("Expected ParseKeyRing() to return an error")
+			}
+		})
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	oldFile := writePrivateKey(t, oldKey)
+	newFile := writePrivateKey(t, newKey)
+
+	ringFile := filepath.Join(t.TempDir(), "keyring.json")
+	writeRing := func(config string) {
+		if err := os.WriteFile(ringFile, []byte(config), 0600); err != nil {
+			t.Fatalf("Failed to write key ring: %v", err)
+		}
+		// Make sure the reload is not hidden by a coarse mtime resolution.
+		later := time.Now().Add(time.Duration(len(config)) * time.Second)
+		os.Chtimes(ringFile, later, later)
+	}
+	t.Setenv("JWT_KEYRING_FILE", ringFile)
+
+	// The new key is published ahead of time but not used yet.
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	writeRing(fmt.Sprintf(`{"keys": [
+		{"kid": "old", "alg": "ES256", "private_key_file": %q},
+		{"kid": "new", "alg": "ES256", "private_key_file": %q, "activate_at": %q}
+	]}`, oldFile, newFile, future))
+
+	token, err := GenerateToken(models.User{ID: 1, Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("GenerateToken() returned error: %v", err)
+	}
+
+	set, _ := JWKS()
+	if len(set.Keys) != 2 {
+		t.Errorf("Expected both keys to be published, got %d", len(set.Keys))
+	}
+
+	// Promote the new key; tokens signed with the old one keep working.
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	writeRing(fmt.Sprintf(`{"keys": [
+		{"kid": "old", "alg": "ES256", "private_key_file": %q},
+		{"kid": "new", "alg": "ES256", "private_key_file": %q, "activate_at": %q}
+	]}`, oldFile, newFile, past))
+
+	if _, err := ParseToken(token); err != nil {
+		t.Errorf("Expected token signed with the previous key to verify, got %v", err)
+	}
+
+	// Retire the old key; its tokens are rejected from then on.
+	writeRing(fmt.Sprintf(`{"keys": [
+		{"kid": "old", "alg": "ES256", "private_key_file": %q, "retire_at": %q},
+		{"kid": "new", "alg": "ES256", "private_key_file": %q, "activate_at": %q}
+	]}`, oldFile, past, newFile, "2000-01-01T00:00:00Z"))
+
+	if _, err := ParseToken(token); err == nil {
+		t.Error("Expected token signed with a retired key to be rejected")
+	}
+
+	set, _ = JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "new" {
+		t.Errorf("Expected only the new key to be published, got %+v", set.Keys)
+	}
+}
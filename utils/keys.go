@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+// SigningKey is a key tokens are signed and verified with. For HS256 both
+// Private and Public hold the shared secret, for the asymmetric algorithms
+// they hold a crypto.Signer and its public key. ActivateAt and RetireAt are
+// only used by key rings; zero values mean "always".
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	Private    interface{}
+	Public     interface{}
+	ActivateAt time.Time
+	RetireAt   time.Time
+}
+
+var (
+	keyCacheMu sync.Mutex
+	keyCache   = map[string]*SigningKey{}
+)
+
+// LoadSigningKey returns the key configured through JWT_SIGNING_ALG. HS256,
+// the default, uses the JWT secret. RS256, ES256 and EdDSA read a PEM encoded
+// private key from JWT_PRIVATE_KEY_FILE or from the Secrets Manager secret in
+// JWT_PRIVATE_KEY_SECRET_ARN. JWT_KEY_ID overrides the key ID, which otherwise
+// is the RFC 7638 thumbprint of the public key.
+func LoadSigningKey() (*SigningKey, error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" || alg == jwt.SigningMethodHS256.Alg() {
+		return hmacSigningKey()
+	}
+
+	keyFile := os.Getenv("JWT_PRIVATE_KEY_FILE")
+	secretArn := os.Getenv("JWT_PRIVATE_KEY_SECRET_ARN")
+	kid := os.Getenv("JWT_KEY_ID")
+
+	var pemData []byte
+	switch {
+	case keyFile != "":
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key file: %w", err)
+		}
+		pemData = data
+	case secretArn != "":
+		// Served from the secrets cache, so this is cheap and picks up
+		// rotated versions.
+		secret, err := GetSecretValue(secretArn)
+		if err != nil {
+			return nil, err
+		}
+		pemData = []byte(secret)
+	default:
+		return nil, fmt.Errorf("%s requires JWT_PRIVATE_KEY_FILE or JWT_PRIVATE_KEY_SECRET_ARN", alg)
+	}
+
+	// Parsing PEM is not something we want to do for every request.
+	sum := sha256.Sum256(pemData)
+	cacheKey := fmt.Sprintf("%s|%s|%x", alg, kid, sum)
+
+	keyCacheMu.Lock()
+	defer keyCacheMu.Unlock()
+
+	if key, ok := keyCache[cacheKey]; ok {
+		return key, nil
+	}
+
+	key, err := ParseSigningKey(alg, kid, pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCache[cacheKey] = key
+	return key, nil
+}
+
+func hmacSigningKey() (*SigningKey, error) {
+	// Get JWT secret from Secrets Manager or environment variable
+	secret, err := GetJWTSecretFromSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	kid := os.Getenv("JWT_KEY_ID")
+	if kid == "" {
+		kid = "default"
+	}
+
+	return newHMACSigningKey(kid, secret)
+}
+
+func newHMACSigningKey(kid, secret string) (*SigningKey, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("JWT secret is empty")
+	}
+
+	return &SigningKey{
+		ID:      kid,
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}, nil
+}
+
+// ParseSigningKey builds an asymmetric SigningKey for alg out of a PEM encoded
+// private key (PKCS#8, PKCS#1 or SEC 1). An empty kid is replaced by the key's
+// thumbprint.
+func ParseSigningKey(alg, kid string, pemData []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %w", err)
+	}
+
+	key := &SigningKey{ID: kid}
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA key", alg)
+		}
+		if private.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, private, &private.PublicKey
+	case jwt.SigningMethodES256.Alg():
+		private, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok || private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s requires a P-256 key", alg)
+		}
+		key.Method, key.Private, key.Public = jwt.SigningMethodES256, private, &private.PublicKey
+	case jwt.SigningMethodEdDSA.Alg():
+		private, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an Ed25519 key", alg)
+		}
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, private, private.Public()
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	if key.ID == "" {
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		key.ID, err = jwkThumbprint(jwk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// JWK returns the public part of the key as a JSON Web Key. Shared secrets
+// are never published.
+func (k *SigningKey) JWK() (models.JWK, error) {
+	jwk := models.JWK{
+		Use: "sig",
+		Alg: k.Method.Alg(),
+		Kid: k.ID,
+	}
+
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return jwk, fmt.Errorf("key %s has no public JWK representation", k.ID)
+	}
+
+	return jwk, nil
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint: the hash of the required
+// members only, in lexicographic order.
+func jwkThumbprint(jwk models.JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+func writePrivateKey(t *testing.T, key crypto.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+
+	return path
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		alg string
+		kty string
+		key crypto.PrivateKey
+	}{
+		{alg: "RS256", kty: "RSA", key: rsaKey},
+		{alg: "ES256", kty: "EC", key: ecKey},
+		{alg: "EdDSA", kty: "OKP", key: edKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			t.Setenv("JWT_SIGNING_ALG", tt.alg)
+			t.Setenv("JWT_PRIVATE_KEY_FILE", writePrivateKey(t, tt.key))
+
+			token, err := GenerateToken(models.User{ID: 1, Email: "test@example.com"})
+			if err != nil {
+				t.Fatalf("GenerateToken() returned error: %v", err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("Failed to decode token: %v", err)
+			}
+			if parsed.Method.Alg() != tt.alg {
+				t.Errorf("Expected alg %s, got %s", tt.alg, parsed.Method.Alg())
+			}
+
+			if _, err := ParseToken(token); err != nil {
+				t.Errorf("ParseToken() returned error: %v", err)
+			}
+
+			set, err := JWKS()
+			if err != nil {
+				t.Fatalf("JWKS() returned error: %v", err)
+			}
+			if len(set.Keys) != 1 {
+				t.Fatalf("Expected 1 key in JWKS, got %d", len(set.Keys))
+			}
+			if set.Keys[0].Kty != tt.kty || set.Keys[0].Kid != parsed.Header["kid"] {
+				t.Errorf("JWKS key %+v does not match token kid %v", set.Keys[0], parsed.Header["kid"])
+			}
+		})
+	}
+}
+
+func TestAsymmetricSigningRejectsHMAC(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	t.Setenv("JWT_SIGNING_ALG", "ES256")
+	t.Setenv("JWT_PRIVATE_KEY_FILE", writePrivateKey(t, ecKey))
+	t.Setenv("SECRET", "test-secret-key")
+
+	// A token signed with a shared secret must not pass when we sign
+	// asymmetrically, whatever key ID it claims.
+	key, err := LoadSigningKey()
+	if err != nil {
+		t.Fatalf("LoadSigningKey() returned error: %v", err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"})
+	forged.Header["kid"] = key.ID
+	tokenStr, _ := forged.SignedString([]byte("test-secret-key"))
+
+	if _, err := ParseToken(tokenStr); err == nil {
+		t.Error("Expected ParseToken() to reject an HS256 token")
+	}
+}
+
+func TestHMACSigningPublishesNoKeys(t *testing.T) {
+	t.Setenv("SECRET", "test-secret-key")
+
+	set, err := JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() returned error: %v", err)
+	}
+
+	if len(set.Keys) != 0 {
+		t.Errorf("Expected no keys in JWKS, got %d", len(set.Keys))
+	}
+}
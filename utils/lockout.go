@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoginThrottle is how failed logins are slowed down, either per account or
+// per client IP. The first BackoffAfter failures are free; each one after
+// that doubles the wait before the next attempt, starting at BackoffBase and
+// capped at BackoffMax. LockoutAfter failures block all attempts for
+// LockoutDuration. Failures are forgotten Window after the last one.
+type LoginThrottle struct {
+	BackoffAfter    int64
+	BackoffBase     time.Duration
+	BackoffMax      time.Duration
+	LockoutAfter    int64
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// AccountLoginThrottle is applied per email address and configured through
+// LOGIN_BACKOFF_AFTER (default 3), LOGIN_BACKOFF_BASE (default "1s"),
+// LOGIN_BACKOFF_MAX (default "5m"), LOGIN_LOCKOUT_AFTER (default 10, 0 turns
+// lockouts off), LOGIN_LOCKOUT_DURATION (default "15m") and
+// LOGIN_FAILURE_WINDOW (default "15m").
+func AccountLoginThrottle() LoginThrottle {
+	return LoginThrottle{
+		BackoffAfter:    intFromEnv("LOGIN_BACKOFF_AFTER", 3),
+		BackoffBase:     durationFromEnv("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:      durationFromEnv("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		LockoutAfter:    intFromEnv("LOGIN_LOCKOUT_AFTER", 10),
+		LockoutDuration: durationFromEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		Window:          durationFromEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+	}
+}
+
+// IPLoginThrottle is applied per client IP, catching one client guessing
+// across many accounts. It allows more failures before kicking in, set with
+// LOGIN_IP_BACKOFF_AFTER (default 20) and LOGIN_IP_LOCKOUT_AFTER (default
+// 100); the other settings are shared with AccountLoginThrottle.
+func IPLoginThrottle() LoginThrottle {
+	throttle := AccountLoginThrottle()
+	throttle.BackoffAfter = intFromEnv("LOGIN_IP_BACKOFF_AFTER", 20)
+	throttle.LockoutAfter = intFromEnv("LOGIN_IP_LOCKOUT_AFTER", 100)
+
+	return throttle
+}
+
+// Delay returns how long to wait after the given number of failures before
+// the next attempt.
+func (t LoginThrottle) Delay(failures int64) time.Duration {
+	if failures <= t.BackoffAfter {
+		return 0
+	}
+
+	delay := t.BackoffBase
+	for i := t.BackoffAfter + 1; i < failures && delay < t.BackoffMax; i++ {
+		delay *= 2
+	}
+
+	if delay > t.BackoffMax {
+		return t.BackoffMax
+	}
+	return delay
+}
+
+// Locks reports whether the given number of failures locks the account or
+// IP out.
+func (t LoginThrottle) Locks(failures int64) bool {
+	return t.LockoutAfter > 0 && failures >= t.LockoutAfter
+}
+
+func intFromEnv(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s value %q, using default of %d", key, value, fallback)
+		return fallback
+	}
+
+	return n
+}
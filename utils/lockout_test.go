@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleDelay(t *testing.T) {
+	throttle := LoginThrottle{BackoffAfter: 3, BackoffBase: time.Second, BackoffMax: 10 * time.Second}
+
+	tests := []struct {
+		failures int64
+		expected time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{1000, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := throttle.Delay(tt.failures); got != tt.expected {
+			t.Errorf("Delay(%d) = %s, expected %s", tt.failures, got, tt.expected)
+		}
+	}
+}
+
+func TestLoginThrottleLocks(t *testing.T) {
+	throttle := LoginThrottle{LockoutAfter: 10}
+	if throttle.Locks(9) || !throttle.Locks(10) {
+		t.Error("Expected the tenth failure to lock")
+	}
+
+	throttle.LockoutAfter = 0
+	if throttle.Locks(1000) {
+		t.Error("Expected LockoutAfter 0 to never lock")
+	}
+}
+
+func TestLoginThrottleFromEnv(t *testing.T) {
+	os.Setenv("LOGIN_LOCKOUT_AFTER", "5")
+	os.Setenv("LOGIN_IP_BACKOFF_AFTER", "not a number")
+	defer os.Unsetenv("LOGIN_LOCKOUT_AFTER")
+	defer os.Unsetenv("LOGIN_IP_BACKOFF_AFTER")
+
+	if throttle := AccountLoginThrottle(); throttle.LockoutAfter != 5 || throttle.BackoffAfter != 3 {
+		t.Errorf("Unexpected account throttle %+v", throttle)
+	}
+
+	if throttle := IPLoginThrottle(); throttle.BackoffAfter != 20 || throttle.LockoutAfter != 100 {
+		t.Errorf("Unexpected IP throttle %+v", throttle)
+	}
+}
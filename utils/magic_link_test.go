@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestMagicLinkToken(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	user := models.User{ID: 1, Email: "test@example.com"}
+
+	token, jti, err := GenerateMagicLinkToken(user, "my-spa", "abc")
+	if err != nil {
+		t.Fatalf("GenerateMagicLinkToken() returned error: %v", err)
+	}
+
+	claims, err := ParseMagicLinkToken(token)
+	if err != nil {
+		t.Fatalf("ParseMagicLinkToken() returned error: %v", err)
+	}
+
+	if claims["sub"] != "1" || claims["email"] != user.Email || claims["jti"] != jti || claims["client_id"] != "my-spa" || claims["nonce"] != "abc" {
+		t.Errorf("Unexpected claims %v", claims)
+	}
+
+	if _, err := ParseToken(token); err == nil {
+		t.Error("Expected ParseToken() to reject a magic link token")
+	}
+
+	if _, err := ParseEmailVerificationToken(token); err == nil {
+		t.Error("Expected ParseEmailVerificationToken() to reject a magic link token")
+	}
+
+	verificationToken, _, _ := GenerateEmailVerificationToken(user)
+	if _, err := ParseMagicLinkToken(verificationToken); err == nil {
+		t.Error("Expected ParseMagicLinkToken() to reject a verification token")
+	}
+
+	token, _, _ = GenerateMagicLinkToken(user, "", "")
+	claims, _ = ParseMagicLinkToken(token)
+	if _, ok := claims["client_id"]; ok {
+		t.Errorf("Expected no client_id claim, got %v", claims)
+	}
+}
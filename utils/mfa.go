@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+// Authentication method references of RFC 8176, used in the amr claim.
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa"
+
+	// AMREmail marks a login through a link sent by email, which RFC 8176
+	// has no value for.
+	AMREmail = "email"
+)
+
+const (
+	// MFAChallengeTTL is how long the user has to enter the second factor
+	// after the password was accepted.
+	MFAChallengeTTL = 5 * time.Minute
+	// MFAChallengeAttempts is how many wrong codes a challenge survives.
+	MFAChallengeAttempts = 5
+
+	mfaChallengeTokenType = "mfa-challenge+jwt"
+)
+
+// GenerateMFAChallengeToken returns the token a login hands out in place of
+// the real tokens when a second factor is due. It records how far the user
+// got (amr) and what the login asked for, so it can be completed later. Its
+// jti is returned too, so the caller can make the token single-use.
+func GenerateMFAChallengeToken(user models.User, amr []string, clientID, nonce string) (string, string, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	claims := jwt.MapClaims{
+		"sub": strconv.Itoa(user.ID),
+		"jti": jti,
+		"amr": amr,
+		"iss": Issuer(),
+		"exp": time.Now().Add(MFAChallengeTTL).Unix(),
+		"iat": time.Now().Unix(),
+	}
+
+	if clientID != "" {
+		claims["client_id"] = clientID
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	token, err := signTypedToken(claims, mfaChallengeTokenType)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, jti, nil
+}
+
+// ParseMFAChallengeToken checks a token made by GenerateMFAChallengeToken and
+// returns its claims.
+func ParseMFAChallengeToken(tokenStr string) (jwt.MapClaims, error) {
+	return parseTypedToken(tokenStr, mfaChallengeTokenType)
+}
+
+// ClaimAMR returns the amr claim as a string slice.
+func ClaimAMR(claims jwt.MapClaims) []string {
+	return claimStrings(claims, "amr")
+}
+
+// claimStrings returns a claim holding a list of strings as a string slice,
+// whether it was set in this process or parsed from JSON.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	if values, ok := claims[name].([]string); ok {
+		return values
+	}
+
+	values, _ := claims[name].([]interface{})
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+
+	return strs
+}
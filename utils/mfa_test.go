@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestMFAChallengeToken(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	token, jti, err := GenerateMFAChallengeToken(models.User{ID: 1}, []string{AMRPassword}, "my-spa", "abc")
+	if err != nil {
+		t.Fatalf("GenerateMFAChallengeToken() returned error: %v", err)
+	}
+
+	claims, err := ParseMFAChallengeToken(token)
+	if err != nil {
+		t.Fatalf("ParseMFAChallengeToken() returned error: %v", err)
+	}
+
+	if claims["sub"] != "1" || claims["jti"] != jti || claims["client_id"] != "my-spa" || claims["nonce"] != "abc" {
+		t.Errorf("Unexpected claims %v", claims)
+	}
+
+	if amr := ClaimAMR(claims); len(amr) != 1 || amr[0] != AMRPassword {
+		t.Errorf("Expected amr [pwd], got %v", amr)
+	}
+
+	// A challenge must not work as an access token, or the second factor
+	// could be skipped.
+	if _, err := ParseToken(token); err == nil {
+		t.Error("Expected ParseToken() to reject an MFA challenge token")
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+// Issuer returns the iss claim of our tokens, configurable through
+// JWT_ISSUER. OpenID Connect clients expect it to be the https URL the app is
+// reachable at.
+func Issuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+
+	return "course"
+}
+
+// AppBaseURL returns APP_BASE_URL, the public http(s) URL the app is
+// reachable at, without a trailing slash. Links sent by mail are built from
+// it rather than from the request, whose Host header the client picks.
+func AppBaseURL() (string, error) {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		return "", errors.New("APP_BASE_URL is not set")
+	}
+
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("APP_BASE_URL %q is not an http(s) URL", base)
+	}
+
+	return strings.TrimRight(base, "/"), nil
+}
+
+// GenerateIDToken returns an OpenID Connect ID token for user, issued to the
+// client audience. nonce is echoed back when the client sent one, authTime is
+// when the user actually authenticated and amr how.
+func GenerateIDToken(user models.User, audience, nonce string, authTime time.Time, amr []string) (string, error) {
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss":            Issuer(),
+		"sub":            strconv.Itoa(user.ID),
+		"aud":            audience,
+		"exp":            now.Add(AccessTokenTTL()).Unix(),
+		"iat":            now.Unix(),
+		"auth_time":      authTime.Unix(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	if len(amr) > 0 {
+		claims["amr"] = amr
+	}
+
+	return SignToken(claims)
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestGenerateIDToken(t *testing.T) {
+	t.Setenv("SECRET", "test-secret-key")
+	t.Setenv("JWT_ISSUER", "https://auth.example.com")
+
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	user := models.User{ID: 7, Email: "test@example.com"}
+
+	token, err := GenerateIDToken(user, "my-client", "n-0S6_WzA2Mj", authTime, []string{AMRPassword, AMROTP, AMRMultiFactor})
+	if err != nil {
+		t.Fatalf("GenerateIDToken() returned error: %v", err)
+	}
+
+	parsed, err := jwt.Parse(token, verificationKey, jwt.WithIssuer("https://auth.example.com"), jwt.WithAudience("my-client"))
+	if err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+
+	expected := map[string]interface{}{
+		"sub":       "7",
+		"nonce":     "n-0S6_WzA2Mj",
+		"email":     "test@example.com",
+		"auth_time": float64(authTime.Unix()),
+	}
+	for claim, value := range expected {
+		if claims[claim] != value {
+			t.Errorf("Expected %s to be %v, got %v", claim, value, claims[claim])
+		}
+	}
+
+	if amr, _ := claims["amr"].([]interface{}); len(amr) != 3 || amr[1] != "otp" {
+		t.Errorf("Expected amr [pwd otp mfa], got %v", claims["amr"])
+	}
+
+	// ID tokens carry no jti, so they can't be passed off as access tokens.
+	if _, ok := claims["jti"]; ok {
+		t.Error("Expected ID token to have no jti")
+	}
+}
+
+func TestParseTokenChecksIssuer(t *testing.T) {
+	t.Setenv("SECRET", "test-secret-key")
+	t.Setenv("JWT_ISSUER", "https://auth.example.com")
+
+	token, err := GenerateToken(models.User{ID: 1, Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("GenerateToken() returned error: %v", err)
+	}
+
+	t.Setenv("JWT_ISSUER", "https://other.example.com")
+
+	if _, err := ParseToken(token); err == nil {
+		t.Error("Expected ParseToken() to reject a token from another issuer")
+	}
+}
+
+func TestAppBaseURL(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{value: "https://auth.example.com", expected: "https://auth.example.com", valid: true},
+		{value: "https://auth.example.com/", expected: "https://auth.example.com", valid: true},
+		{value: "http://localhost:8080/app/", expected: "http://localhost:8080/app", valid: true},
+		{value: ""},
+		{value: "auth.example.com"},
+		{value: "ftp://auth.example.com"},
+		{value: "https://"},
+		{value: "https://user@auth.example.com"},
+		{value: "https://auth.example.com/?next=x"},
+		{value: "https://auth.example.com/#x"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("APP_BASE_URL", tt.value)
+
+		base, err := AppBaseURL()
+		if tt.valid && err != nil {
+			t.Errorf("AppBaseURL() with %q returned error: %v", tt.value, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected AppBaseURL() to reject %q", tt.value)
+		}
+		if base != tt.expected {
+			t.Errorf("Expected AppBaseURL() with %q to be %q, got %q", tt.value, tt.expected, base)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Values of PASSWORD_HASH.
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// argon2idMaxBytes keeps absurdly long passwords from costing more than
+// hashing them is worth; argon2id itself takes any length.
+const argon2idMaxBytes = 1024
+
+var errUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords in one format and checks passwords against
+// hashes in that format.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether hash is in another format or was made
+	// with other parameters than the hasher's own.
+	NeedsRehash(hash string) bool
+	// MaxBytes is the longest password the hasher takes in full.
+	MaxBytes() int64
+}
+
+// BcryptHasher makes "$2a$" hashes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+func (h BcryptHasher) MaxBytes() int64 {
+	return bcryptMaxBytes
+}
+
+// Argon2idHasher makes hashes in the PHC string format,
+// "$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>".
+// Memory is in KiB.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idHash struct {
+	Argon2idHasher
+	salt []byte
+	key  []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(hash, password string) (bool, error) {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), parsed.salt, parsed.Iterations, parsed.Memory, parsed.Parallelism, parsed.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	return err != nil || parsed.Argon2idHasher != h
+}
+
+func (h Argon2idHasher) MaxBytes() int64 {
+	return argon2idMaxBytes
+}
+
+func parseArgon2idHash(hash string) (argon2idHash, error) {
+	var parsed argon2idHash
+	var version int
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != PasswordHashArgon2id {
+		return parsed, errUnknownPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return parsed, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.Memory, &parsed.Iterations, &parsed.Parallelism); err != nil {
+		return parsed, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return parsed, errors.New("invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return parsed, errors.New("invalid argon2id key")
+	}
+
+	parsed.salt, parsed.key = salt, key
+	parsed.SaltLength, parsed.KeyLength = uint32(len(salt)), uint32(len(key))
+
+	return parsed, nil
+}
+
+// PasswordHasherFromEnv returns the hasher new passwords are hashed with.
+// PASSWORD_HASH picks "argon2id", the default, or "bcrypt". argon2id is
+// tuned with ARGON2_MEMORY in KiB (default 19456), ARGON2_ITERATIONS
+// (default 2) and ARGON2_PARALLELISM (default 1), the OWASP recommendation;
+// bcrypt with BCRYPT_COST (default 10).
+func PasswordHasherFromEnv() PasswordHasher {
+	switch alg := os.Getenv("PASSWORD_HASH"); alg {
+	case PasswordHashBcrypt:
+		cost := int(intFromEnv("BCRYPT_COST", int64(bcrypt.DefaultCost)))
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			log.Printf("Invalid BCRYPT_COST value %d, using default of %d", cost, bcrypt.DefaultCost)
+			cost = bcrypt.DefaultCost
+		}
+		return BcryptHasher{Cost: cost}
+	case "", PasswordHashArgon2id:
+	default:
+		log.Printf("Unknown PASSWORD_HASH value %q, using argon2id", alg)
+	}
+
+	hasher := Argon2idHasher{
+		Memory:      uint32(intFromEnv("ARGON2_MEMORY", 19456)),
+		Iterations:  uint32(intFromEnv("ARGON2_ITERATIONS", 2)),
+		Parallelism: uint8(intFromEnv("ARGON2_PARALLELISM", 1)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	if hasher.Iterations == 0 || hasher.Parallelism == 0 || hasher.Memory < 8*uint32(hasher.Parallelism) {
+		log.Printf("Invalid argon2id parameters %+v, using defaults", hasher)
+		hasher.Memory, hasher.Iterations, hasher.Parallelism = 19456, 2, 1
+	}
+
+	return hasher
+}
+
+// passwordHasherOf identifies the hasher a stored hash was made with.
+func passwordHasherOf(hash string) (PasswordHasher, error) {
+	switch {
+	case strings.HasPrefix(hash, "$"+PasswordHashArgon2id+"$"):
+		return Argon2idHasher{}, nil
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return BcryptHasher{}, nil
+	}
+
+	return nil, errUnknownPasswordHash
+}
+
+// HashPassword hashes a new password with the configured hasher.
+func HashPassword(password string) (string, error) {
+	return PasswordHasherFromEnv().Hash(password)
+}
+
+// VerifyPassword checks password against a stored hash of any supported
+// format. When it matches, rehash reports whether the hash should be
+// replaced by HashPassword's, because it is in another format or made with
+// other parameters than the configured ones.
+func VerifyPassword(hash, password string) (ok bool, rehash bool) {
+	hasher, err := passwordHasherOf(hash)
+	if err != nil {
+		log.Println(err)
+		return false, false
+	}
+
+	ok, err = hasher.Verify(hash, password)
+	if err != nil {
+		log.Println(err)
+		return false, false
+	}
+	if !ok {
+		return false, false
+	}
+
+	return true, PasswordHasherFromEnv().NeedsRehash(hash)
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	hash, err := hasher.Hash("correct horse battery")
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Unexpected hash %q", hash)
+	}
+
+	other, _ := hasher.Hash("correct horse battery")
+	if other == hash {
+		t.Error("Expected every hash to get its own salt")
+	}
+
+	if ok, err := hasher.Verify(hash, "correct horse battery"); !ok || err != nil {
+		t.Errorf("Verify = %v, %v, expected a match", ok, err)
+	}
+	if ok, err := hasher.Verify(hash, "wrong horse battery"); ok || err != nil {
+		t.Errorf("Verify = %v, %v, expected a mismatch", ok, err)
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Error("Expected a hash with the hasher's own parameters to be kept")
+	}
+	stronger := hasher
+	stronger.Iterations = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Error("Expected a hash with other parameters to need a rehash")
+	}
+
+	for _, bad := range []string{"", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=x$c2FsdA$a2V5"} {
+		if ok, err := hasher.Verify(bad, "correct horse battery"); ok || err == nil {
+			t.Errorf("Expected Verify(%q) to fail", bad)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	os.Setenv("ARGON2_MEMORY", "64")
+	os.Setenv("ARGON2_ITERATIONS", "1")
+	defer os.Unsetenv("ARGON2_MEMORY")
+	defer os.Unsetenv("ARGON2_ITERATIONS")
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An old bcrypt hash still works and is upgraded to argon2id.
+	if ok, rehash := VerifyPassword(string(bcryptHash), "correct horse battery"); !ok || !rehash {
+		t.Errorf("VerifyPassword(bcrypt) = %v, %v, expected a match needing a rehash", ok, rehash)
+	}
+	if ok, rehash := VerifyPassword(string(bcryptHash), "wrong"); ok || rehash {
+		t.Errorf("VerifyPassword(bcrypt) = %v, %v, expected a mismatch", ok, rehash)
+	}
+
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Errorf("Expected an argon2id hash by default, got %q", hash)
+	}
+	if ok, rehash := VerifyPassword(hash, "correct horse battery"); !ok || rehash {
+		t.Errorf("VerifyPassword(argon2id) = %v, %v, expected a match as it is", ok, rehash)
+	}
+
+	// Going back to bcrypt, or raising its cost, rehashes again.
+	os.Setenv("PASSWORD_HASH", "bcrypt")
+	os.Setenv("BCRYPT_COST", "5")
+	defer os.Unsetenv("PASSWORD_HASH")
+	defer os.Unsetenv("BCRYPT_COST")
+
+	if ok, rehash := VerifyPassword(hash, "correct horse battery"); !ok || !rehash {
+		t.Errorf("VerifyPassword(argon2id) = %v, %v, expected a match needing a rehash", ok, rehash)
+	}
+	if ok, rehash := VerifyPassword(string(bcryptHash), "correct horse battery"); !ok || !rehash {
+		t.Errorf("VerifyPassword(bcrypt) = %v, %v, expected the cost change to need a rehash", ok, rehash)
+	}
+
+	if ok, _ := VerifyPassword("plaintext", "plaintext"); ok {
+		t.Error("Expected an unknown hash format never to match")
+	}
+}
@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+// bcryptMaxBytes is the most bcrypt looks at; anything longer is rejected
+// rather than silently cut short.
+const bcryptMaxBytes = 72
+
+// Codes of the field errors CheckPassword returns.
+const (
+	PasswordTooShort         = "too_short"
+	PasswordTooLong          = "too_long"
+	PasswordCharacterClasses = "character_classes"
+	PasswordMatchesEmail     = "matches_email"
+	PasswordBreached         = "breached"
+)
+
+// PasswordPolicy is what a new password has to satisfy. MinLength counts
+// characters, MaxBytes bytes. MinClasses is how many of lower case, upper
+// case, digits and other characters it must mix.
+type PasswordPolicy struct {
+	MinLength  int64
+	MaxBytes   int64
+	MinClasses int64
+}
+
+// PasswordPolicyFromEnv is configured through PASSWORD_MIN_LENGTH (default
+// 10), PASSWORD_MAX_BYTES (default and at most what the password hasher
+// takes, 72 for bcrypt and 1024 for argon2id) and PASSWORD_MIN_CLASSES
+// (default 2).
+func PasswordPolicyFromEnv() PasswordPolicy {
+	maxBytes := PasswordHasherFromEnv().MaxBytes()
+
+	policy := PasswordPolicy{
+		MinLength:  intFromEnv("PASSWORD_MIN_LENGTH", 10),
+		MaxBytes:   intFromEnv("PASSWORD_MAX_BYTES", maxBytes),
+		MinClasses: intFromEnv("PASSWORD_MIN_CLASSES", 2),
+	}
+	if policy.MaxBytes <= 0 || policy.MaxBytes > maxBytes {
+		policy.MaxBytes = maxBytes
+	}
+
+	return policy
+}
+
+// Check returns the rules password breaks, as errors of field. email is the
+// address of the account, which the password must not be.
+func (p PasswordPolicy) Check(field, password, email string) []models.FieldError {
+	var errs []models.FieldError
+
+	if int64(utf8.RuneCountInString(password)) < p.MinLength {
+		errs = append(errs, models.FieldError{Field: field, Code: PasswordTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters long.", p.MinLength)})
+	}
+
+	if int64(len(password)) > p.MaxBytes {
+		errs = append(errs, models.FieldError{Field: field, Code: PasswordTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes long.", p.MaxBytes)})
+	}
+
+	if characterClasses(password) < p.MinClasses {
+		errs = append(errs, models.FieldError{Field: field, Code: PasswordCharacterClasses,
+			Message: fmt.Sprintf("Password must mix at least %d of lower case letters, upper case letters, digits and symbols.", p.MinClasses)})
+	}
+
+	if matchesEmail(password, email) {
+		errs = append(errs, models.FieldError{Field: field, Code: PasswordMatchesEmail,
+			Message: "Password must not be your email address."})
+	}
+
+	return errs
+}
+
+func characterClasses(password string) int64 {
+	var lower, upper, digit, other int64
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+// matchesEmail reports whether password is the email address or the part
+// before the @, ignoring case.
+func matchesEmail(password, email string) bool {
+	password = strings.ToLower(strings.TrimSpace(password))
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+	return password == email || password == local
+}
+
+// BreachedPasswords looks passwords up in a file of SHA-1 hashes of
+// passwords known from breaches, sorted in ascending order, one hash per
+// line in upper or lower case hex. Anything after a colon is ignored, so the
+// Have I Been Pwned "ordered by hash" download works as it is. Lookups are a
+// binary search over the file, which is never loaded into memory.
+type BreachedPasswords struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// maxBreachedLine bounds how far a lookup reads looking for the end of a
+// line; a HIBP line is under 60 bytes.
+const maxBreachedLine = 4096
+
+// NewBreachedPasswords returns the list held in the size bytes of r. It
+// checks that the first line is a hash, to catch the wrong file early.
+func NewBreachedPasswords(r io.ReaderAt, size int64) (*BreachedPasswords, error) {
+	b := &BreachedPasswords{r: r, size: size}
+
+	if size > 0 {
+		if _, _, _, err := b.lineAt(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// lineAt returns the hash on the first line starting at or after offset,
+// where that line starts and where the next one does. A start of b.size
+// means there is no such line.
+func (b *BreachedPasswords) lineAt(offset int64) ([sha1.Size]byte, int64, int64, error) {
+	var hash [sha1.Size]byte
+
+	start := offset
+	if offset > 0 {
+		// Look from the byte before, so a line starting right at offset is
+		// found too.
+		buf, err := b.readLine(offset - 1)
+		if err != nil {
+			return hash, 0, 0, err
+		}
+		start = offset - 1 + int64(len(buf))
+	}
+	if start >= b.size {
+		return hash, b.size, b.size, nil
+	}
+
+	buf, err := b.readLine(start)
+	if err != nil {
+		return hash, 0, 0, err
+	}
+
+	text, _, _ := bytes.Cut(bytes.TrimSpace(buf), []byte(":"))
+	if n, err := hex.Decode(hash[:], text); err != nil || n != sha1.Size {
+		return hash, 0, 0, fmt.Errorf("breached password list has a line at offset %d that is not a SHA-1 hash", start)
+	}
+
+	return hash, start, start + int64(len(buf)), nil
+}
+
+// readLine returns the bytes from offset up to and including the next
+// newline, or to the end of the file.
+func (b *BreachedPasswords) readLine(offset int64) ([]byte, error) {
+	buf := make([]byte, maxBreachedLine)
+
+	n, err := b.r.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return buf[:i+1], nil
+	}
+	if offset+int64(n) < b.size {
+		return nil, fmt.Errorf("breached password list has a line longer than %d bytes", maxBreachedLine)
+	}
+
+	return buf, nil
+}
+
+// Contains reports whether password is on the list. A nil list contains
+// nothing.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	wanted := sha1.Sum([]byte(password))
+
+	// lo is always the start of a line; the hash, if listed, is on a line
+	// starting in [lo, hi).
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		hash, start, next, err := b.lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		switch cmp := bytes.Compare(hash[:], wanted[:]); {
+		case cmp == 0:
+			return true, nil
+		case cmp < 0:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+
+	return false, nil
+}
+
+var (
+	breachedPasswordsMu      sync.Mutex
+	breachedPasswordsPath    string
+	breachedPasswordsModTime time.Time
+	breachedPasswords        *BreachedPasswords
+)
+
+// LoadBreachedPasswords returns the list in the file PASSWORD_BREACHED_LIST
+// names, or nil when it is unset. The file is opened once and reopened when
+// it changes; it has to be sorted, as BreachedPasswords describes. The file
+// being replaced isn't closed, as lookups may still be reading it; it is
+// closed once nothing refers to it any more.
+func LoadBreachedPasswords() (*BreachedPasswords, error) {
+	path := os.Getenv("PASSWORD_BREACHED_LIST")
+	if path == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read breached password list: %w", err)
+	}
+
+	breachedPasswordsMu.Lock()
+	defer breachedPasswordsMu.Unlock()
+
+	if breachedPasswords != nil && breachedPasswordsPath == path && breachedPasswordsModTime.Equal(info.ModTime()) {
+		return breachedPasswords, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read breached password list: %w", err)
+	}
+
+	breached, err := NewBreachedPasswords(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid breached password list: %w", err)
+	}
+
+	breachedPasswords = breached
+	breachedPasswordsPath = path
+	breachedPasswordsModTime = info.ModTime()
+
+	return breached, nil
+}
+
+// CheckPassword applies PasswordPolicyFromEnv and the breached password list
+// to a new password. The error is about loading the list, not the password.
+func CheckPassword(field, password, email string) ([]models.FieldError, error) {
+	errs := PasswordPolicyFromEnv().Check(field, password, email)
+
+	breached, err := LoadBreachedPasswords()
+	if err != nil {
+		return nil, err
+	}
+
+	listed, err := breached.Contains(password)
+	if err != nil {
+		return nil, err
+	}
+	if listed {
+		errs = append(errs, models.FieldError{Field: field, Code: PasswordBreached,
+			Message: "Password appears in a known data breach, choose another one."})
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func errorCodes(errs []models.FieldError) []string {
+	codes := []string{}
+	for _, e := range errs {
+		codes = append(codes, e.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, MaxBytes: 72, MinClasses: 2}
+
+	tests := []struct {
+		password string
+		codes    string
+	}{
+		{"correct horse battery", ""},
+		{"Tr0ub4dor&3", ""},
+		{"x", "too_short,character_classes"},
+		{"abcdefghijkl", "character_classes"},
+		{"ÄÖÜäöüÄÖÜä", ""},
+		{strings.Repeat("aB", 37), "too_long"},
+		{"Someone@Example.com", "matches_email"},
+		{"someone.else", ""},
+	}
+
+	for _, test := range tests {
+		errs := policy.Check("password", test.password, "someone@example.com")
+		if codes := strings.Join(errorCodes(errs), ","); codes != test.codes {
+			t.Errorf("Check(%q) = %q, expected %q", test.password, codes, test.codes)
+		}
+		for _, e := range errs {
+			if e.Field != "password" || e.Message == "" {
+				t.Errorf("Unexpected field error %+v", e)
+			}
+		}
+	}
+
+	if errs := policy.Check("password", "longpasswd", "longpasswd@example.com"); len(errs) != 2 {
+		t.Errorf("Expected the local part of the address to be refused, got %v", errorCodes(errs))
+	}
+}
+
+func TestPasswordPolicyFromEnv(t *testing.T) {
+	if policy := PasswordPolicyFromEnv(); policy.MaxBytes != 1024 || policy.MinLength != 10 || policy.MinClasses != 2 {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	os.Setenv("PASSWORD_HASH", "bcrypt")
+	os.Setenv("PASSWORD_MAX_BYTES", "1000")
+	defer os.Unsetenv("PASSWORD_HASH")
+	defer os.Unsetenv("PASSWORD_MAX_BYTES")
+
+	if policy := PasswordPolicyFromEnv(); policy.MaxBytes != 72 {
+		t.Errorf("Expected bcrypt to cap the length at 72 bytes, got %d", policy.MaxBytes)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" in lower case and of "password1" in upper case
+	// with a count and CRLF, as in the HIBP download, plus filler lines.
+	list := "0000000000000000000000000000000000000001:1\r\n" +
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8\r\n" +
+		"E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\r\n" +
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:3"
+
+	breached, err := NewBreachedPasswords(strings.NewReader(list), int64(len(list)))
+	if err != nil {
+		t.Fatalf("NewBreachedPasswords failed: %v", err)
+	}
+	for _, password := range []string{"password", "password1"} {
+		if found, err := breached.Contains(password); !found || err != nil {
+			t.Errorf("Contains(%q) = %v, %v, expected it to be found", password, found, err)
+		}
+	}
+	if found, err := breached.Contains("Password1"); found || err != nil {
+		t.Errorf("Contains(\"Password1\") = %v, %v, expected it not to be found", found, err)
+	}
+
+	var nothing *BreachedPasswords
+	if found, err := nothing.Contains("password"); found || err != nil {
+		t.Error("Expected a nil list to contain nothing")
+	}
+
+	if _, err := NewBreachedPasswords(strings.NewReader("password1\n"), 10); err == nil {
+		t.Error("Expected a file that isn't hashes to fail")
+	}
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PASSWORD_BREACHED_LIST", path)
+	defer os.Unsetenv("PASSWORD_BREACHED_LIST")
+
+	errs, err := CheckPassword("new_password", "password1", "someone@example.com")
+	if err != nil {
+		t.Fatalf("CheckPassword failed: %v", err)
+	}
+	if codes := strings.Join(errorCodes(errs), ","); codes != "too_short,breached" {
+		t.Errorf("CheckPassword = %q", codes)
+	}
+
+	os.Setenv("PASSWORD_BREACHED_LIST", filepath.Join(t.TempDir(), "missing.txt"))
+	if _, err := CheckPassword("password", "correct horse battery", ""); err == nil {
+		t.Error("Expected a missing list to fail")
+	}
+}
+
+func TestBreachedPasswordsSearch(t *testing.T) {
+	var hashes []string
+	for i := 0; i < 1000; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("listed-%d", i)))
+		hashes = append(hashes, fmt.Sprintf("%X:%d", sum, i))
+	}
+	sort.Strings(hashes)
+	list := strings.Join(hashes, "\n") + "\n"
+
+	breached, err := NewBreachedPasswords(strings.NewReader(list), int64(len(list)))
+	if err != nil {
+		t.Fatalf("NewBreachedPasswords failed: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if found, err := breached.Contains(fmt.Sprintf("listed-%d", i)); !found || err != nil {
+			t.Fatalf("Contains(listed-%d) = %v, %v", i, found, err)
+		}
+		if found, err := breached.Contains(fmt.Sprintf("unlisted-%d", i)); found || err != nil {
+			t.Fatalf("Contains(unlisted-%d) = %v, %v", i, found, err)
+		}
+	}
+}
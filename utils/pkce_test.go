@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636, appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		expected  bool
+	}{
+		{name: "Valid verifier", verifier: verifier, challenge: challenge, expected: true},
+		{name: "Wrong verifier", verifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXX", challenge: challenge, expected: false},
+		{name: "Plain method", verifier: verifier, challenge: verifier, expected: false},
+		{name: "Too short", verifier: "abc", challenge: challenge, expected: false},
+		{name: "Empty challenge", verifier: verifier, challenge: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, tt.challenge); got != tt.expected {
+				t.Errorf("VerifyPKCE() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
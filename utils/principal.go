@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Principal is who a verified access token speaks for. Subject is the user
+// ID, or the client ID for tokens from the client credentials grant.
+type Principal struct {
+	Subject       string        `json:"sub"`
+	Email         string        `json:"email,omitempty"`
+	EmailVerified bool          `json:"email_verified"`
+	Roles         []string      `json:"roles,omitempty"`
+	Scopes        []string      `json:"scopes,omitempty"`
+	AMR           []string      `json:"amr,omitempty"`
+	ClientID      string        `json:"client_id,omitempty"`
+	TokenID       string        `json:"jti"`
+	Claims        jwt.MapClaims `json:"-"`
+}
+
+// NewPrincipal reads a Principal from the claims of an access token, which
+// must have been verified already.
+func NewPrincipal(claims jwt.MapClaims) Principal {
+	p := Principal{
+		Roles:  ClaimRoles(claims),
+		Scopes: ClaimScopes(claims),
+		AMR:    ClaimAMR(claims),
+		Claims: claims,
+	}
+
+	p.Subject, _ = claims.GetSubject()
+	p.Email, _ = claims["email"].(string)
+	p.EmailVerified, _ = claims["email_verified"].(bool)
+	p.ClientID, _ = claims["client_id"].(string)
+	p.TokenID, _ = claims["jti"].(string)
+
+	return p
+}
+
+// UserID returns the subject as a user ID. It fails for tokens a client
+// holds on its own behalf.
+func (p Principal) UserID() (int, error) {
+	return strconv.Atoi(p.Subject)
+}
+
+// HasRole reports whether the principal has role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScopes reports whether the principal was granted every one of scopes.
+func (p Principal) HasScopes(scopes ...string) bool {
+	return HasScopes(p.Claims, scopes...)
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying p.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the Principal TokenVerifyMiddleware or
+// RequireScope put in the request context, and false for requests that
+// didn't go through either.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestPrincipal(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	user := models.User{ID: 7, Email: "admin@example.com", EmailVerified: true, Roles: []string{"admin"}, Permissions: []string{"users:delete"}}
+	token, err := GenerateTokenForClient(user, "", []string{AMRPassword, AMROTP, AMRMultiFactor})
+	if err != nil {
+		t.Fatalf("GenerateTokenForClient() returned error: %v", err)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken() returned error: %v", err)
+	}
+
+	p := NewPrincipal(claims)
+	if p.Subject != "7" || p.Email != "admin@example.com" || !p.EmailVerified || p.TokenID == "" || p.TokenID != claims["jti"] {
+		t.Errorf("Unexpected principal %+v", p)
+	}
+	if !reflect.DeepEqual(p.AMR, []string{"pwd", "otp", "mfa"}) {
+		t.Errorf("Unexpected amr %v", p.AMR)
+	}
+	if id, err := p.UserID(); err != nil || id != 7 {
+		t.Errorf("UserID() = %d, %v", id, err)
+	}
+	if !p.HasRole("admin") || p.HasRole("user") {
+		t.Error("HasRole() doesn't match the roles claim")
+	}
+	if !p.HasScopes("users:delete") || p.HasScopes("roles:assign") {
+		t.Error("HasScopes() doesn't match the scope claim")
+	}
+
+	ctx := ContextWithPrincipal(context.Background(), p)
+	if got, ok := PrincipalFromContext(ctx); !ok || got.TokenID != p.TokenID {
+		t.Errorf("PrincipalFromContext() = %+v, %v", got, ok)
+	}
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Error("Expected no principal in an empty context")
+	}
+
+	token, _ = GenerateClientToken("billing-service", "users:read")
+	claims, _ = ParseToken(token)
+	p = NewPrincipal(claims)
+	if _, err := p.UserID(); err == nil {
+		t.Error("Expected a client token not to have a user ID")
+	}
+	if p.ClientID != "billing-service" || !p.HasScopes("users:read") {
+		t.Errorf("Unexpected client principal %+v", p)
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit allows Limit requests in any Window. It is enforced as a sliding
+// window: the count of the previous fixed window is weighted by how much of
+// it still overlaps the sliding one. A zero Limit means no limit.
+type RateLimit struct {
+	Limit  int64
+	Window time.Duration
+}
+
+// ParseRateLimit parses a limit written as "<requests>/<window>", e.g.
+// "100/1m". "off" turns limiting off.
+func ParseRateLimit(spec string) (RateLimit, error) {
+	if spec == "off" {
+		return RateLimit{}, nil
+	}
+
+	count, window, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not of the form <requests>/<window>", spec)
+	}
+
+	limit, err := strconv.ParseInt(count, 10, 64)
+	if err != nil || limit <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid request count in rate limit %q", spec)
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d < time.Second {
+		return RateLimit{}, fmt.Errorf("invalid window in rate limit %q", spec)
+	}
+
+	return RateLimit{Limit: limit, Window: d}, nil
+}
+
+// RateLimitFromEnv returns the limit of the named route from
+// RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_LOGIN="10/1m", or fallback when unset.
+func RateLimitFromEnv(name string, fallback RateLimit) RateLimit {
+	key := "RATE_LIMIT_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	limit, err := ParseRateLimit(value)
+	if err != nil {
+		log.Printf("Invalid %s value: %v, using default of %d/%s", key, err, fallback.Limit, fallback.Window)
+		return fallback
+	}
+
+	return limit
+}
+
+// Used estimates the requests in the sliding window, given the counts of the
+// previous and current fixed windows and how far into the current one we are.
+func (l RateLimit) Used(previous, current int64, elapsed time.Duration) float64 {
+	overlap := float64(l.Window-elapsed) / float64(l.Window)
+	return float64(previous)*overlap + float64(current)
+}
+
+// Remaining returns how many more requests the window allows.
+func (l RateLimit) Remaining(previous, current int64, elapsed time.Duration) int64 {
+	remaining := int64(math.Floor(float64(l.Limit) - l.Used(previous, current, elapsed)))
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// RetryAfter returns how long until another request would be allowed,
+// assuming none come in meanwhile.
+func (l RateLimit) RetryAfter(previous, current int64, elapsed time.Duration) time.Duration {
+	allowed := float64(l.Limit - 1)
+	window := float64(l.Window)
+	untilNext := l.Window - elapsed
+
+	if current <= l.Limit-1 {
+		if previous == 0 {
+			return 0
+		}
+		// Wait for enough of the previous window to slide out.
+		wait := untilNext - time.Duration((allowed-float64(current))*window/float64(previous))
+		if wait < 0 {
+			return 0
+		}
+		return wait
+	}
+
+	// The current window alone is over the limit, so wait for it to become
+	// the previous one and slide out far enough.
+	wait := window - allowed*window/float64(current)
+	if wait < 0 {
+		wait = 0
+	}
+	return untilNext + time.Duration(wait)
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("100/1m")
+	if err != nil || limit.Limit != 100 || limit.Window != time.Minute {
+		t.Errorf("ParseRateLimit(\"100/1m\") = %+v, %v", limit, err)
+	}
+
+	if limit, err := ParseRateLimit("off"); err != nil || limit.Limit != 0 {
+		t.Errorf("ParseRateLimit(\"off\") = %+v, %v", limit, err)
+	}
+
+	for _, spec := range []string{"", "100", "0/1m", "-1/1m", "100/x", "100/10ms"} {
+		if _, err := ParseRateLimit(spec); err == nil {
+			t.Errorf("Expected ParseRateLimit(%q) to fail", spec)
+		}
+	}
+}
+
+func TestRateLimitFromEnv(t *testing.T) {
+	fallback := RateLimit{Limit: 10, Window: time.Minute}
+
+	os.Setenv("RATE_LIMIT_LOGIN_MFA", "5/30s")
+	defer os.Unsetenv("RATE_LIMIT_LOGIN_MFA")
+
+	if limit := RateLimitFromEnv("login-mfa", fallback); limit.Limit != 5 || limit.Window != 30*time.Second {
+		t.Errorf("Unexpected limit %+v", limit)
+	}
+
+	os.Setenv("RATE_LIMIT_LOGIN_MFA", "lots")
+	if limit := RateLimitFromEnv("login-mfa", fallback); limit != fallback {
+		t.Errorf("Expected the fallback for an invalid value, got %+v", limit)
+	}
+
+	if limit := RateLimitFromEnv("token", fallback); limit != fallback {
+		t.Errorf("Expected the fallback when unset, got %+v", limit)
+	}
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	limit := RateLimit{Limit: 10, Window: 10 * time.Second}
+
+	// Halfway into the window, half of the previous window still counts.
+	if used := limit.Used(10, 2, 5*time.Second); used != 7 {
+		t.Errorf("Used() = %v, expected 7", used)
+	}
+	if remaining := limit.Remaining(10, 2, 5*time.Second); remaining != 3 {
+		t.Errorf("Remaining() = %d, expected 3", remaining)
+	}
+	if remaining := limit.Remaining(10, 20, 5*time.Second); remaining != 0 {
+		t.Errorf("Remaining() = %d, expected 0", remaining)
+	}
+
+	// 10 * (10s - 5s - t) / 10s + 5 <= 9 once t >= 1s.
+	if wait := limit.RetryAfter(10, 5, 5*time.Second); wait != time.Second {
+		t.Errorf("RetryAfter() = %s, expected 1s", wait)
+	}
+
+	// With the current window full, wait for the next one and for a tenth of
+	// it to slide out.
+	if wait := limit.RetryAfter(0, 10, 5*time.Second); wait != 6*time.Second {
+		t.Errorf("RetryAfter() = %s, expected 6s", wait)
+	}
+
+	if wait := limit.RetryAfter(0, 3, 5*time.Second); wait != 0 {
+		t.Errorf("RetryAfter() = %s, expected 0", wait)
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimRoles returns the roles claim of an access token.
+func ClaimRoles(claims jwt.MapClaims) []string {
+	return claimStrings(claims, "roles")
+}
+
+// ClaimScopes returns the space separated scope claim of an access token as
+// a slice. For users these are the permissions their roles grant, for
+// clients the scopes they were granted.
+func ClaimScopes(claims jwt.MapClaims) []string {
+	scope, _ := claims["scope"].(string)
+	return strings.Fields(scope)
+}
+
+// HasScopes reports whether the token's scope claim includes every one of
+// scopes.
+func HasScopes(claims jwt.MapClaims, scopes ...string) bool {
+	granted := ClaimScopes(claims)
+
+	for _, scope := range scopes {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
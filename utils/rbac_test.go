@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestRolesAndScopesClaims(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	user := models.User{ID: 1, Email: "admin@example.com", Roles: []string{"admin"}, Permissions: []string{"roles:assign", "users:delete"}}
+
+	token, err := GenerateToken(user)
+	if err != nil {
+		t.Fatalf("GenerateToken() returned error: %v", err)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken() returned error: %v", err)
+	}
+
+	if roles := ClaimRoles(claims); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("ClaimRoles() = %v", roles)
+	}
+	if claims["scope"] != "roles:assign users:delete" {
+		t.Errorf("Unexpected scope claim %v", claims["scope"])
+	}
+
+	if !HasScopes(claims, "users:delete") || !HasScopes(claims, "users:delete", "roles:assign") || !HasScopes(claims) {
+		t.Error("Expected the granted scopes to be found")
+	}
+	if HasScopes(claims, "users:delete", "users:read") {
+		t.Error("Expected a missing scope to fail the check")
+	}
+
+	token, _ = GenerateToken(models.User{ID: 2, Email: "test@example.com"})
+	claims, _ = ParseToken(token)
+	if _, ok := claims["roles"]; ok {
+		t.Error("Expected no roles claim for a user without roles")
+	}
+	if HasScopes(claims, "users:delete") {
+		t.Error("Expected a user without roles to have no scopes")
+	}
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns n one-time recovery codes like
+// "k3xq-7hbd-pm2a-uv5c". Each carries 80 random bits, plenty for storing them
+// as a plain SHA-256 with HashRecoveryCode.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		raw := recoveryCodeEncoding.EncodeToString(b)
+		codes = append(codes, strings.Join([]string{raw[0:4], raw[4:8], raw[8:12], raw[12:16]}, "-"))
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode hashes a recovery code the way it is stored, ignoring case,
+// dashes and spaces so users can type it however they like.
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	return HashToken(normalized)
+}
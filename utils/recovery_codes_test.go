@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() returned error: %v", err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("Unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := HashRecoveryCode("k3xq-7hbd-pm2a-uv5c")
+
+	for _, typed := range []string{"K3XQ-7HBD-PM2A-UV5C", "k3xq7hbdpm2auv5c", "k3xq 7hbd pm2a uv5c"} {
+		if HashRecoveryCode(typed) != hash {
+			t.Errorf("Expected %q to hash like the original code", typed)
+		}
+	}
+
+	if HashRecoveryCode("k3xq-7hbd-pm2a-uv5d") == hash {
+		t.Error("Expected a different code to hash differently")
+	}
+}
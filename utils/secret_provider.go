@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the secrets the app needs, independent of where they are stored.
+const (
+	SecretDBPassword    = "db_password"
+	SecretJWT           = "jwt_secret"
+	SecretRedisPassword = "redis_password"
+	SecretSMTPPassword  = "smtp_password"
+)
+
+// SecretProvider looks secrets up by name.
+type SecretProvider interface {
+	GetSecret(name string) (string, error)
+}
+
+// GetSecretProvider returns the provider selected by SECRETS_PROVIDER: "env",
+// "file", "aws" or "vault". When unset it is "aws" if any of the *_SECRET_ARN
+// variables is set and "env" otherwise, which is how things worked before
+// providers existed.
+func GetSecretProvider() (SecretProvider, error) {
+	name := os.Getenv("SECRETS_PROVIDER")
+	if name == "" {
+		name = "env"
+		for _, envVar := range awsSecretArnEnv {
+			if os.Getenv(envVar) != "" {
+				name = "aws"
+			}
+		}
+	}
+
+	switch name {
+	case "env":
+		return EnvSecretProvider{}, nil
+	case "file":
+		dir := os.Getenv("SECRETS_DIR")
+		if dir == "" {
+			dir = "/var/run/secrets/jwtapp"
+		}
+		return FileSecretProvider{Dir: dir}, nil
+	case "aws":
+		cache, err := DefaultSecretCache()
+		if err != nil {
+			return nil, err
+		}
+		return AWSSecretProvider{Cache: cache}, nil
+	case "vault":
+		return defaultVaultSecretProvider()
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", name)
+	}
+}
+
+var envSecretVars = map[string]string{
+	SecretDBPassword:    "DB_PASSWORD",
+	SecretJWT:           "SECRET",
+	SecretRedisPassword: "REDIS_PASSWORD",
+	SecretSMTPPassword:  "SMTP_PASSWORD",
+}
+
+// EnvSecretProvider reads secrets from the environment variables the app has
+// always used: DB_PASSWORD, SECRET, REDIS_PASSWORD and SMTP_PASSWORD. Other
+// names map to their upper-cased form.
+type EnvSecretProvider struct{}
+
+func (p EnvSecretProvider) GetSecret(name string) (string, error) {
+	envVar, ok := envSecretVars[name]
+	if !ok {
+		envVar = strings.ToUpper(name)
+	}
+
+	return os.Getenv(envVar), nil
+}
+
+// FileSecretProvider reads each secret from a file named after it in Dir,
+// which is what a Kubernetes secret mounted as a volume looks like.
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p FileSecretProvider) GetSecret(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret %s: %w", name, err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var awsSecretArnEnv = map[string]string{
+	SecretDBPassword:    "DB_PASSWORD_SECRET_ARN",
+	SecretJWT:           "JWT_SECRET_ARN",
+	SecretRedisPassword: "REDIS_PASSWORD_SECRET_ARN",
+	SecretSMTPPassword:  "SMTP_PASSWORD_SECRET_ARN",
+}
+
+// AWSSecretProvider reads secrets from AWS Secrets Manager, using the ARN in
+// the matching *_SECRET_ARN variable. Secrets without an ARN configured come
+// from the environment instead. The DB password secret is the JSON document
+// RDS generates, of which only the "password" field is used.
+type AWSSecretProvider struct {
+	Cache *SecretCache
+}
+
+func (p AWSSecretProvider) GetSecret(name string) (string, error) {
+	secretArn := os.Getenv(awsSecretArnEnv[name])
+	if secretArn == "" {
+		return EnvSecretProvider{}.GetSecret(name)
+	}
+
+	value, err := p.Cache.Get(secretArn)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving %s from Secrets Manager: %w", name, err)
+	}
+
+	if name != SecretDBPassword {
+		return value, nil
+	}
+
+	var secretData map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &secretData); err != nil {
+		return "", fmt.Errorf("error parsing DB secret JSON: %w", err)
+	}
+
+	if password, ok := secretData["password"].(string); ok {
+		return password, nil
+	}
+
+	return "", fmt.Errorf("password not found in DB secret")
+}
+
+// VaultSecretProvider reads secrets from a single HashiCorp Vault KV version 2
+// secret, one field per secret name. Values are cached the same way Secrets
+// Manager values are.
+type VaultSecretProvider struct {
+	Addr  string
+	Mount string
+	Path  string
+
+	mu    sync.Mutex
+	token string
+	cache *SecretCache
+}
+
+var (
+	vaultProvidersMu sync.Mutex
+	vaultProviders   = map[string]*VaultSecretProvider{}
+)
+
+// defaultVaultSecretProvider is configured through VAULT_ADDR, VAULT_TOKEN (or
+// VAULT_TOKEN_FILE, e.g. written by the Vault agent), VAULT_KV_MOUNT
+// (default "secret") and VAULT_SECRET_PATH (default "jwtapp"). There is one
+// provider, and one refresh loop, per secret: a rotated token is handed to
+// the existing provider.
+func defaultVaultSecretProvider() (*VaultSecretProvider, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is required for the vault secrets provider")
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if tokenFile := os.Getenv("VAULT_TOKEN_FILE"); tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read Vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	mount := os.Getenv("VAULT_KV_MOUNT")
+	if mount == "" {
+		mount = "secret"
+	}
+
+	path := os.Getenv("VAULT_SECRET_PATH")
+	if path == "" {
+		path = "jwtapp"
+	}
+
+	vaultProvidersMu.Lock()
+	defer vaultProvidersMu.Unlock()
+
+	key := strings.Join([]string{addr, mount, path}, "|")
+	if provider, ok := vaultProviders[key]; ok {
+		provider.SetToken(token)
+		return provider, nil
+	}
+
+	provider := NewVaultSecretProvider(addr, token, mount, path, secretsCacheTTL())
+	vaultProviders[key] = provider
+
+	return provider, nil
+}
+
+func NewVaultSecretProvider(addr, token, mount, path string, ttl time.Duration) *VaultSecretProvider {
+	p := &VaultSecretProvider{
+		Addr:  strings.TrimRight(addr, "/"),
+		Mount: strings.Trim(mount, "/"),
+		Path:  strings.Trim(path, "/"),
+		token: token,
+	}
+	p.cache = newSecretCache(p.read, ttl)
+
+	return p
+}
+
+// SetToken replaces the Vault token used from the next read on.
+func (p *VaultSecretProvider) SetToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = token
+}
+
+func (p *VaultSecretProvider) currentToken() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token
+}
+
+func (p *VaultSecretProvider) GetSecret(name string) (string, error) {
+	document, err := p.cache.Get(p.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(document), &fields); err != nil {
+		return "", fmt.Errorf("error parsing Vault secret: %w", err)
+	}
+
+	value, ok := fields[name].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s not found in Vault at %s/%s", name, p.Mount, p.Path)
+	}
+
+	return value, nil
+}
+
+// read fetches the whole KV secret; its fields are returned as a JSON document
+// so the cache can keep it as a single value.
+func (p *VaultSecretProvider) read(ctx context.Context, path string) (string, string, error) {
+	url := fmt.Sprintf("%s/v1/%s/data/%s", p.Addr, p.Mount, path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("X-Vault-Token", p.currentToken())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("unable to read secret from Vault: %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data     json.RawMessage `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", "", fmt.Errorf("error parsing Vault response: %w", err)
+	}
+
+	return string(body.Data.Data), strconv.Itoa(body.Data.Metadata.Version), nil
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetSecretProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{name: "Default", env: map[string]string{}, expected: "utils.EnvSecretProvider"},
+		{name: "ARN configured", env: map[string]string{"JWT_SECRET_ARN": "arn:aws:secretsmanager:us-east-1:123456789012:secret:jwt"}, expected: "utils.AWSSecretProvider"},
+		{name: "File", env: map[string]string{"SECRETS_PROVIDER": "file"}, expected: "utils.FileSecretProvider"},
+		{name: "Vault", env: map[string]string{"SECRETS_PROVIDER": "vault", "VAULT_ADDR": "http://127.0.0.1:8200"}, expected: "*utils.VaultSecretProvider"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", "us-east-1")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			provider, err := GetSecretProvider()
+			if err != nil {
+				t.Fatalf("GetSecretProvider() returned error: %v", err)
+			}
+
+			if got := typeName(provider); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	t.Setenv("SECRETS_PROVIDER", "carrier-pigeon")
+	if _, err := GetSecretProvider(); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case EnvSecretProvider:
+		return "utils.EnvSecretProvider"
+	case FileSecretProvider:
+		return "utils.FileSecretProvider"
+	case AWSSecretProvider:
+		return "utils.AWSSecretProvider"
+	case *VaultSecretProvider:
+		return "*utils.VaultSecretProvider"
+	}
+	return "unknown"
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	t.Setenv("SECRET", "jwt-secret")
+	t.Setenv("DB_PASSWORD", "db-password")
+
+	provider := EnvSecretProvider{}
+
+	if value, _ := provider.GetSecret(SecretJWT); value != "jwt-secret" {
+		t.Errorf("Expected jwt-secret, got %s", value)
+	}
+
+	if value, _ := provider.GetSecret(SecretDBPassword); value != "db-password" {
+		t.Errorf("Expected db-password, got %s", value)
+	}
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, SecretJWT), []byte("file-secret\n"), 0600)
+
+	provider := FileSecretProvider{Dir: dir}
+
+	value, err := provider.GetSecret(SecretJWT)
+	if err != nil {
+		t.Fatalf("GetSecret() returned error: %v", err)
+	}
+	if value != "file-secret" {
+		t.Errorf("Expected file-secret, got %q", value)
+	}
+
+	if _, err := provider.GetSecret(SecretRedisPassword); err == nil {
+		t.Error("Expected an error for a missing secret file")
+	}
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		if r.URL.Path != "/v1/secret/data/jwtapp" || r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]string{SecretJWT: "vault-secret", SecretDBPassword: "vault-db"},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	defer server.Close()
+
+	provider := NewVaultSecretProvider(server.URL, "test-token", "secret", "jwtapp", time.Minute)
+	defer provider.cache.Close()
+
+	if value, err := provider.GetSecret(SecretJWT); err != nil || value != "vault-secret" {
+		t.Errorf("Expected vault-secret, got %q, %v", value, err)
+	}
+
+	if value, err := provider.GetSecret(SecretDBPassword); err != nil || value != "vault-db" {
+		t.Errorf("Expected vault-db, got %q, %v", value, err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected Vault to be called once, got %d", calls)
+	}
+
+	if provider.cache.Version("jwtapp") != "3" {
+		t.Errorf("Expected version 3, got %s", provider.cache.Version("jwtapp"))
+	}
+
+	if _, err := provider.GetSecret(SecretRedisPassword); err == nil {
+		t.Error("Expected an error for a field missing from the Vault secret")
+	}
+
+	denied := NewVaultSecretProvider(server.URL, "wrong-token", "secret", "jwtapp", time.Minute)
+	defer denied.cache.Close()
+
+	if _, err := denied.GetSecret(SecretJWT); err == nil {
+		t.Error("Expected an error when Vault denies access")
+	}
+}
+
+func TestVaultTokenRotation(t *testing.T) {
+	tokens := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("X-Vault-Token")
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]string{SecretJWT: "vault-secret"},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN_FILE", tokenFile)
+	t.Setenv("VAULT_SECRET_PATH", "rotation")
+
+	first, err := defaultVaultSecretProvider()
+	if err != nil {
+		t.Fatalf("defaultVaultSecretProvider() returned error: %v", err)
+	}
+	defer first.cache.Close()
+
+	if _, err := first.GetSecret(SecretJWT); err != nil {
+		t.Fatalf("GetSecret() returned error: %v", err)
+	}
+	if token := <-tokens; token != "first-token" {
+		t.Errorf("Expected first-token, got %q", token)
+	}
+
+	if err := os.WriteFile(tokenFile, []byte("second-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := defaultVaultSecretProvider()
+	if err != nil {
+		t.Fatalf("defaultVaultSecretProvider() returned error: %v", err)
+	}
+	if second != first {
+		t.Error("Expected the provider to be reused after the token rotated")
+	}
+
+	if _, _, err := second.read(context.Background(), second.Path); err != nil {
+		t.Fatalf("read() returned error: %v", err)
+	}
+	if token := <-tokens; token != "second-token" {
+		t.Errorf("Expected second-token, got %q", token)
+	}
+}
@@ -2,87 +2,269 @@ package utils
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 )
 
-// GetSecretValue retrieves a secret value from AWS Secrets Manager
-func GetSecretValue(secretArn string) (string, error) {
-	if secretArn == "" {
+const (
+	defaultSecretsCacheTTL = 5 * time.Minute
+	minSecretsCacheTTL     = 2 * time.Second
+
+	// minSecretsRefreshInterval keeps a tiny TTL from refreshing in a busy
+	// loop, or from panicking the ticker.
+	minSecretsRefreshInterval = 50 * time.Millisecond
+)
+
+// secretsCacheTTL returns SECRETS_CACHE_TTL. Values under two seconds would
+// have Vault or Secrets Manager polled all the time, so they are refused.
+func secretsCacheTTL() time.Duration {
+	ttl := durationFromEnv("SECRETS_CACHE_TTL", defaultSecretsCacheTTL)
+	if ttl < minSecretsCacheTTL {
+		log.Printf("SECRETS_CACHE_TTL of %s is below the minimum of %s, using default of %s", ttl, minSecretsCacheTTL, defaultSecretsCacheTTL)
+		return defaultSecretsCacheTTL
+	}
+
+	return ttl
+}
+
+// SecretsManagerAPI is the part of the Secrets Manager client we use, so a
+// fake can stand in for it.
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+type cachedSecret struct {
+	value     string
+	versionID string
+	expiresAt time.Time
+}
+
+// secretFetcher returns the value and version of a secret.
+type secretFetcher func(ctx context.Context, secretID string) (string, string, error)
+
+// SecretCache keeps secret values in memory for a TTL and refreshes them in
+// the background before they expire. If a refresh fails the cached value is
+// served until it expires; after that Get returns an error instead of a stale
+// or made up value.
+type SecretCache struct {
+	fetchSecret secretFetcher
+	ttl         time.Duration
+
+	mu      sync.RWMutex
+	secrets map[string]*cachedSecret
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+}
+
+// NewSecretCache returns a cache in front of Secrets Manager.
+func NewSecretCache(client SecretsManagerAPI, ttl time.Duration) *SecretCache {
+	return newSecretCache(func(ctx context.Context, secretID string) (string, string, error) {
+		result, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretID),
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("unable to retrieve secret: %w", err)
+		}
+
+		if result.SecretString == nil {
+			return "", "", fmt.Errorf("secret %s has no string value", secretID)
+		}
+
+		return *result.SecretString, aws.ToString(result.VersionId), nil
+	}, ttl)
+}
+
+func newSecretCache(fetch secretFetcher, ttl time.Duration) *SecretCache {
+	return &SecretCache{
+		fetchSecret: fetch,
+		ttl:         ttl,
+		secrets:     map[string]*cachedSecret{},
+		stop:        make(chan struct{}),
+	}
+}
+
+// Get returns the current value of the secret.
+func (c *SecretCache) Get(secretID string) (string, error) {
+	if secretID == "" {
 		return "", fmt.Errorf("secret ARN is empty")
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	c.startOnce.Do(func() { go c.refreshLoop() })
+
+	c.mu.RLock()
+	secret, ok := c.secrets[secretID]
+	c.mu.RUnlock()
+
+	if ok && time.Now().Before(secret.expiresAt) {
+		return secret.value, nil
+	}
+
+	secret, err := c.fetch(secretID)
 	if err != nil {
-		return "", fmt.Errorf("unable to load SDK config: %w", err)
+		return "", err
 	}
 
-	client := secretsmanager.NewFromConfig(cfg)
+	return secret.value, nil
+}
+
+// Version returns the Secrets Manager version ID of the cached value, or an
+// empty string when the secret has not been fetched yet.
+func (c *SecretCache) Version(secretID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	input := &secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(secretArn),
+	if secret, ok := c.secrets[secretID]; ok {
+		return secret.versionID
 	}
 
-	result, err := client.GetSecretValue(context.TODO(), input)
+	return ""
+}
+
+// Close stops the background refresh.
+func (c *SecretCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func (c *SecretCache) fetch(secretID string) (*cachedSecret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	value, versionID, err := c.fetchSecret(ctx, secretID)
 	if err != nil {
-		return "", fmt.Errorf("unable to retrieve secret: %w", err)
+		return nil, err
+	}
+
+	secret := &cachedSecret{
+		value:     value,
+		versionID: versionID,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	if previous, ok := c.secrets[secretID]; ok && previous.versionID != secret.versionID {
+		log.Printf("Secret %s rotated from version %s to %s", secretID, previous.versionID, secret.versionID)
 	}
+	c.secrets[secretID] = secret
+	c.mu.Unlock()
 
-	return *result.SecretString, nil
+	return secret, nil
 }
 
-// GetDBPasswordFromSecret retrieves the database password from Secrets Manager
-func GetDBPasswordFromSecret() string {
-	secretArn := os.Getenv("DB_PASSWORD_SECRET_ARN")
-	if secretArn == "" {
-		// Fallback to environment variable if not using Secrets Manager
-		log.Println("DB_PASSWORD_SECRET_ARN not set, using DB_PASSWORD from environment")
-		return os.Getenv("DB_PASSWORD")
+// refreshInterval is half the TTL, so values are refreshed before they
+// expire, but at least minSecretsRefreshInterval.
+func (c *SecretCache) refreshInterval() time.Duration {
+	if interval := c.ttl / 2; interval > minSecretsRefreshInterval {
+		return interval
 	}
+	return minSecretsRefreshInterval
+}
 
-	secretString, err := GetSecretValue(secretArn)
-	if err != nil {
-		log.Printf("Error retrieving DB password from Secrets Manager: %v", err)
-		// Fallback to environment variable
-		return os.Getenv("DB_PASSWORD")
+func (c *SecretCache) refreshLoop() {
+	ticker := time.NewTicker(c.refreshInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.refreshAll()
+		}
 	}
+}
 
-	// Parse the secret JSON to extract the password
-	var secretData map[string]interface{}
-	if err := json.Unmarshal([]byte(secretString), &secretData); err != nil {
-		log.Printf("Error parsing DB secret JSON: %v", err)
-		return os.Getenv("DB_PASSWORD")
+func (c *SecretCache) refreshAll() {
+	c.mu.RLock()
+	ids := make([]string, 0, len(c.secrets))
+	for id := range c.secrets {
+		ids = append(ids, id)
 	}
+	c.mu.RUnlock()
 
-	if password, ok := secretData["password"].(string); ok {
-		return password
+	for _, id := range ids {
+		if _, err := c.fetch(id); err != nil {
+			log.Printf("Error refreshing secret %s, keeping the cached value until it expires: %v", id, err)
+		}
 	}
+}
+
+var (
+	defaultSecretCacheMu sync.Mutex
+	defaultSecretCache   *SecretCache
+)
+
+// DefaultSecretCache returns the process wide cache in front of AWS Secrets
+// Manager. The AWS config is loaded once, so AWS_REGION, AWS_ENDPOINT_URL and
+// friends apply; the TTL comes from SECRETS_CACHE_TTL.
+func DefaultSecretCache() (*SecretCache, error) {
+	defaultSecretCacheMu.Lock()
+	defer defaultSecretCacheMu.Unlock()
 
-	log.Println("Password not found in secret, using DB_PASSWORD from environment")
-	return os.Getenv("DB_PASSWORD")
+	if defaultSecretCache != nil {
+		return defaultSecretCache, nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	defaultSecretCache = NewSecretCache(secretsmanager.NewFromConfig(cfg), secretsCacheTTL())
+	return defaultSecretCache, nil
 }
 
-// GetJWTSecretFromSecret retrieves the JWT secret from Secrets Manager
-func GetJWTSecretFromSecret() string {
-	secretArn := os.Getenv("JWT_SECRET_ARN")
+// GetSecretValue retrieves a secret value from AWS Secrets Manager
+func GetSecretValue(secretArn string) (string, error) {
 	if secretArn == "" {
-		// Fallback to environment variable if not using Secrets Manager
-		log.Println("JWT_SECRET_ARN not set, using SECRET from environment")
-		return os.Getenv("SECRET")
+		return "", fmt.Errorf("secret ARN is empty")
 	}
 
-	secretString, err := GetSecretValue(secretArn)
+	cache, err := DefaultSecretCache()
+	if err != nil {
+		return "", err
+	}
+
+	return cache.Get(secretArn)
+}
+
+// GetDBPasswordFromSecret retrieves the database password from the configured
+// secret provider.
+func GetDBPasswordFromSecret() (string, error) {
+	return getSecret(SecretDBPassword)
+}
+
+// GetJWTSecretFromSecret retrieves the JWT secret from the configured secret
+// provider. Fetch errors are returned rather than falling back to SECRET, so a
+// misconfigured deployment can't quietly sign tokens with a different key.
+func GetJWTSecretFromSecret() (string, error) {
+	return getSecret(SecretJWT)
+}
+
+// GetRedisPasswordFromSecret retrieves the Redis password from the configured
+// secret provider.
+func GetRedisPasswordFromSecret() (string, error) {
+	return getSecret(SecretRedisPassword)
+}
+
+// GetSMTPPasswordFromSecret retrieves the SMTP password from the configured
+// secret provider.
+func GetSMTPPasswordFromSecret() (string, error) {
+	return getSecret(SecretSMTPPassword)
+}
+
+func getSecret(name string) (string, error) {
+	provider, err := GetSecretProvider()
 	if err != nil {
-		log.Printf("Error retrieving JWT secret from Secrets Manager: %v", err)
-		// Fallback to environment variable
-		return os.Getenv("SECRET")
+		return "", err
 	}
 
-	return secretString
+	return provider.GetSecret(name)
 }
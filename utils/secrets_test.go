@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/jcprz/jwtapp/models"
+)
+
+// fakeSecretsManager speaks just enough of the Secrets Manager JSON protocol
+// for GetSecretValue.
+type fakeSecretsManager struct {
+	mu      sync.Mutex
+	secrets map[string]string
+	version string
+	calls   int
+	failing bool
+}
+
+func (f *fakeSecretsManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+
+	var input struct {
+		SecretId string
+	}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	value, ok := f.secrets[input.SecretId]
+	if f.failing || !ok || r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"__type":  "ResourceNotFoundException",
+			"message": "Secrets Manager can't find the specified secret.",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ARN":           input.SecretId,
+		"Name":          input.SecretId,
+		"SecretString":  value,
+		"VersionId":     f.version,
+		"VersionStages": []string{"AWSCURRENT"},
+	})
+}
+
+func (f *fakeSecretsManager) set(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func (f *fakeSecretsManager) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func newFakeSecretsManager(t *testing.T) (*fakeSecretsManager, SecretsManagerAPI) {
+	t.Helper()
+
+	fake := &fakeSecretsManager{
+		secrets: map[string]string{"jwt-secret": "first-secret"},
+		version: "v1",
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := secretsmanager.New(secretsmanager.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	return fake, client
+}
+
+func TestSecretCache(t *testing.T) {
+	fake, client := newFakeSecretsManager(t)
+
+	cache := NewSecretCache(client, 200*time.Millisecond)
+	defer cache.Close()
+
+	for i := 0; i < 3; i++ {
+		value, err := cache.Get("jwt-secret")
+		if err != nil {
+			t.Fatalf("Get() returned error: %v", err)
+		}
+		if value != "first-secret" {
+			t.Errorf("Expected first-secret, got %s", value)
+		}
+	}
+
+	if calls := fake.callCount(); calls != 1 {
+		t.Errorf("Expected a single call to Secrets Manager, got %d", calls)
+	}
+
+	if cache.Version("jwt-secret") != "v1" {
+		t.Errorf("Expected version v1, got %s", cache.Version("jwt-secret"))
+	}
+
+	// The background refresh picks up the rotated value without anybody
+	// asking for it.
+	fake.set(func() {
+		fake.secrets["jwt-secret"] = "second-secret"
+		fake.version = "v2"
+	})
+	time.Sleep(150 * time.Millisecond)
+
+	if cache.Version("jwt-secret") != "v2" {
+		t.Errorf("Expected background refresh to pick up version v2, got %s", cache.Version("jwt-secret"))
+	}
+
+	value, _ := cache.Get("jwt-secret")
+	if value != "second-secret" {
+		t.Errorf("Expected second-secret, got %s", value)
+	}
+}
+
+func TestSecretCacheFailsClosed(t *testing.T) {
+	fake, client := newFakeSecretsManager(t)
+
+	cache := NewSecretCache(client, 200*time.Millisecond)
+	defer cache.Close()
+
+	if _, err := cache.Get("jwt-secret"); err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+
+	fake.set(func() { fake.failing = true })
+
+	// Refresh failures keep the cached value around until it expires...
+	time.Sleep(120 * time.Millisecond)
+	if value, err := cache.Get("jwt-secret"); err != nil || value != "first-secret" {
+		t.Errorf("Expected cached value before expiry, got %q, %v", value, err)
+	}
+
+	// ...but not beyond that.
+	time.Sleep(150 * time.Millisecond)
+	if _, err := cache.Get("jwt-secret"); err == nil {
+		t.Error("Expected Get() to fail once the cached value expired")
+	}
+
+	if _, err := cache.Get("missing-secret"); err == nil {
+		t.Error("Expected Get() to fail for an unknown secret")
+	}
+}
+
+func TestGetJWTSecretFromSecretFailsClosed(t *testing.T) {
+	fake, client := newFakeSecretsManager(t)
+	fake.set(func() { fake.failing = true })
+
+	cache := NewSecretCache(client, time.Minute)
+	defer cache.Close()
+
+	defaultSecretCacheMu.Lock()
+	previous := defaultSecretCache
+	defaultSecretCache = cache
+	defaultSecretCacheMu.Unlock()
+	defer func() {
+		defaultSecretCacheMu.Lock()
+		defaultSecretCache = previous
+		defaultSecretCacheMu.Unlock()
+	}()
+
+	t.Setenv("JWT_SECRET_ARN", "jwt-secret")
+	t.Setenv("SECRET", "fallback-secret")
+
+	if secret, err := GetJWTSecretFromSecret(); err == nil {
+		t.Errorf("Expected an error instead of falling back, got %q", secret)
+	}
+
+	if _, err := GenerateToken(models.User{ID: 1, Email: "test@example.com"}); err == nil {
+		t.Error("Expected GenerateToken() to fail without the JWT secret")
+	}
+}
+
+func TestSecretsCacheTTL(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: defaultSecretsCacheTTL},
+		{value: "10m", expected: 10 * time.Minute},
+		{value: "2s", expected: 2 * time.Second},
+		{value: "1ns", expected: defaultSecretsCacheTTL},
+		{value: "1s", expected: defaultSecretsCacheTTL},
+		{value: "-5m", expected: defaultSecretsCacheTTL},
+		{value: "soon", expected: defaultSecretsCacheTTL},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SECRETS_CACHE_TTL", tt.value)
+
+		if ttl := secretsCacheTTL(); ttl != tt.expected {
+			t.Errorf("Expected SECRETS_CACHE_TTL %q to give %s, got %s", tt.value, tt.expected, ttl)
+		}
+	}
+}
+
+func TestSecretCacheTinyTTL(t *testing.T) {
+	_, client := newFakeSecretsManager(t)
+
+	// A TTL this small used to panic the refresh ticker.
+	cache := NewSecretCache(client, time.Nanosecond)
+	defer cache.Close()
+
+	if interval := cache.refreshInterval(); interval != minSecretsRefreshInterval {
+		t.Errorf("Expected refresh interval %s, got %s", minSecretsRefreshInterval, interval)
+	}
+
+	if _, err := cache.Get("jwt-secret"); err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	time.Sleep(2 * minSecretsRefreshInterval)
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultAccessTokenTTL   = 15 * time.Minute
+	defaultRefreshTokenTTL  = 30 * 24 * time.Hour
+	defaultPasswordResetTTL = time.Hour
+)
+
+// AccessTokenTTL returns the lifetime of access tokens, configurable through
+// ACCESS_TOKEN_TTL (e.g. "15m").
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL returns the lifetime of refresh tokens, configurable through
+// REFRESH_TOKEN_TTL (e.g. "720h").
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// PasswordResetTTL returns how long password reset links stay valid,
+// configurable through PASSWORD_RESET_TTL (e.g. "1h").
+func PasswordResetTTL() time.Duration {
+	return durationFromEnv("PASSWORD_RESET_TTL", defaultPasswordResetTTL)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s value %q, using default of %s", key, value, fallback)
+		return fallback
+	}
+
+	return d
+}
+
+// GenerateRandomToken returns n bytes of crypto/rand entropy, base64url encoded.
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateRefreshToken returns an opaque refresh token together with the hash
+// that gets persisted. The raw token is only ever handed to the client.
+func GenerateRefreshToken() (string, string, error) {
+	token, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 of an opaque token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
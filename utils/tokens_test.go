@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "Default", value: "", expected: defaultAccessTokenTTL},
+		{name: "Configured", value: "5m", expected: 5 * time.Minute},
+		{name: "Invalid", value: "soon", expected: defaultAccessTokenTTL},
+		{name: "Negative", value: "-1m", expected: defaultAccessTokenTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("ACCESS_TOKEN_TTL", tt.value)
+			defer os.Unsetenv("ACCESS_TOKEN_TTL")
+
+			if got := AccessTokenTTL(); got != tt.expected {
+				t.Errorf("AccessTokenTTL() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateRefreshToken(t *testing.T) {
+	token, hash, err := GenerateRefreshToken()
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken() returned error: %v", err)
+	}
+
+	if token == "" || hash == "" {
+		t.Fatal("GenerateRefreshToken() returned an empty token or hash")
+	}
+
+	if hash != HashToken(token) {
+		t.Error("Expected hash to match HashToken(token)")
+	}
+
+	if hash == token {
+		t.Error("Expected the stored hash to differ from the raw token")
+	}
+
+	other, _, _ := GenerateRefreshToken()
+	if other == token {
+		t.Error("Expected two refresh tokens to differ")
+	}
+}
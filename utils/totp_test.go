@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, truncated to six digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(secret, TOTPCounter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() returned error: %v", err)
+		}
+		if code != tt.expected {
+			t.Errorf("TOTPCode() at %d = %s, expected %s", tt.unix, code, tt.expected)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() returned error: %v", err)
+	}
+
+	now := time.Now()
+	current := TOTPCounter(now)
+
+	previous, _ := TOTPCode(secret, current-1)
+	if counter, ok := ValidateTOTP(secret, previous, now); !ok || counter != current-1 {
+		t.Errorf("Expected the previous code to be accepted with counter %d, got %d, %v", current-1, counter, ok)
+	}
+
+	stale, _ := TOTPCode(secret, current-3)
+	if _, ok := ValidateTOTP(secret, stale, now); ok {
+		t.Error("Expected a code from three periods ago to be rejected")
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("Expected a short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("JBSWY3DPEHPK3PXP", "test@example.com"))
+	if err != nil {
+		t.Fatalf("Unable to parse URI: %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+
+	if !strings.HasSuffix(uri.Path, ":test@example.com") {
+		t.Errorf("Expected the account in the label, got %s", uri.Path)
+	}
+
+	if uri.Query().Get("secret") != "JBSWY3DPEHPK3PXP" || uri.Query().Get("issuer") != "course" {
+		t.Errorf("Unexpected parameters %s", uri.RawQuery)
+	}
+}
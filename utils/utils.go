@@ -2,13 +2,14 @@ package utils
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jcprz/jwtapp/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func ResponseJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -27,18 +28,59 @@ func RespondWithError(w http.ResponseWriter, status int, message string) {
 
 }
 
+// RespondWithFieldErrors is RespondWithError for requests with invalid
+// fields, listing what is wrong with each.
+func RespondWithFieldErrors(w http.ResponseWriter, status int, message string, errs []models.FieldError) {
+	error := models.Error{Message: message, Errors: errs}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(error)
+}
+
+// GenerateToken returns an access token for user after a password login.
 func GenerateToken(user models.User) (string, error) {
-	// Get JWT secret from Secrets Manager or environment variable
-	secret := GetJWTSecretFromSecret()
+	return GenerateTokenForClient(user, "", []string{AMRPassword})
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": user.Email,
-		"iss":   "course",
-		"exp":   time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
-		"iat":   time.Now().Unix(),
-	})
+// GenerateTokenForClient returns an access token for user that was issued
+// through the OAuth client clientID, recorded in the client_id claim so the
+// client can later revoke it. amr lists the authentication methods the user
+// went through (RFC 8176). The user's roles go in the roles claim and the
+// permissions they grant in scope, as RFC 9068 has it.
+func GenerateTokenForClient(user models.User, clientID string, amr []string) (string, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"sub":            strconv.Itoa(user.ID),
+		"jti":            jti,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"iss":            Issuer(),
+		"exp":            time.Now().Add(AccessTokenTTL()).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+
+	if clientID != "" {
+		claims["client_id"] = clientID
+	}
+
+	if len(amr) > 0 {
+		claims["amr"] = amr
+	}
+
+	if len(user.Roles) > 0 {
+		claims["roles"] = user.Roles
+	}
+
+	if len(user.Permissions) > 0 {
+		claims["scope"] = strings.Join(user.Permissions, " ")
+	}
+
+	tokenStr, err := SignToken(claims)
 
-	tokenStr, err := token.SignedString([]byte(secret))
 	if err != nil {
 		return "", err
 	}
@@ -47,13 +89,64 @@ func GenerateToken(user models.User) (string, error) {
 
 }
 
-func ComparePasswords(hashedPassword string, password []byte) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// GenerateClientToken returns an access token for a client acting on its own
+// behalf, as issued by the client credentials grant. The client is the
+// subject and there is no email.
+func GenerateClientToken(clientID, scope string) (string, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"sub":       clientID,
+		"client_id": clientID,
+		"jti":       jti,
+		"iss":       Issuer(),
+		"exp":       time.Now().Add(AccessTokenTTL()).Unix(),
+		"iat":       time.Now().Unix(),
+	}
+
+	if scope != "" {
+		claims["scope"] = scope
+	}
+
+	return SignToken(claims)
+}
+
+// ParseToken checks the signature and standard time based claims of a token
+// issued by GenerateToken and returns its claims. Tokens signed for another
+// purpose, which carry their own typ header, are rejected.
+func ParseToken(tokenStr string) (jwt.MapClaims, error) {
+	return parseTypedToken(tokenStr, "")
+}
+
+func parseTypedToken(tokenStr string, typ string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, verificationKey, jwt.WithIssuer(Issuer()))
 
 	if err != nil {
-		log.Println(err)
-		return false
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	header, _ := token.Header["typ"].(string)
+	if header == "JWT" {
+		header = ""
+	}
+	if header != typ {
+		return nil, fmt.Errorf("invalid token type")
 	}
 
-	return true
+	return claims, nil
+}
+
+// ComparePasswords checks password against a hash of any format
+// VerifyPassword supports.
+func ComparePasswords(hashedPassword string, password []byte) bool {
+	ok, _ := VerifyPassword(hashedPassword, string(password))
+	return ok
 }
@@ -100,6 +100,99 @@ func TestGenerateToken(t *testing.T) {
 	if _, ok := claims["iat"]; !ok {
 		t.Error("Token missing issued at claim")
 	}
+
+	if claims["sub"] != "1" {
+		t.Errorf("Expected subject '1', got %v", claims["sub"])
+	}
+
+	if jti, _ := claims["jti"].(string); jti == "" {
+		t.Error("Token missing token ID claim")
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	token, err := GenerateToken(models.User{ID: 1, Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("GenerateToken() returned error: %v", err)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken() returned error: %v", err)
+	}
+
+	if claims["email"] != "test@example.com" {
+		t.Errorf("Expected email test@example.com, got %v", claims["email"])
+	}
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"}).SignedString([]byte("other-secret"))
+	if _, err := ParseToken(forged); err == nil {
+		t.Error("Expected ParseToken() to reject a token signed with another secret")
+	}
+
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "1"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := ParseToken(unsigned); err == nil {
+		t.Error("Expected ParseToken() to reject an unsigned token")
+	}
+}
+
+func TestGenerateTokenForClient(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	token, err := GenerateTokenForClient(models.User{ID: 1, Email: "test@example.com"}, "my-spa", []string{AMRPassword})
+	if err != nil {
+		t.Fatalf("GenerateTokenForClient() returned error: %v", err)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken() returned error: %v", err)
+	}
+
+	if claims["sub"] != "1" || claims["client_id"] != "my-spa" {
+		t.Errorf("Expected sub 1 and client_id my-spa, got %v and %v", claims["sub"], claims["client_id"])
+	}
+
+	token, _ = GenerateToken(models.User{ID: 1, Email: "test@example.com"})
+	claims, _ = ParseToken(token)
+	if _, ok := claims["client_id"]; ok {
+		t.Error("Tokens from /login should not carry a client_id")
+	}
+}
+
+func TestGenerateClientToken(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	token, err := GenerateClientToken("billing-service", "users:read")
+	if err != nil {
+		t.Fatalf("GenerateClientToken() returned error: %v", err)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken() returned error: %v", err)
+	}
+
+	if claims["sub"] != "billing-service" || claims["client_id"] != "billing-service" {
+		t.Errorf("Expected the client as subject, got sub %v and client_id %v", claims["sub"], claims["client_id"])
+	}
+
+	if claims["scope"] != "users:read" {
+		t.Errorf("Expected scope users:read, got %v", claims["scope"])
+	}
+
+	if _, ok := claims["email"]; ok {
+		t.Error("Client tokens should not carry an email")
+	}
+
+	if jti, _ := claims["jti"].(string); jti == "" {
+		t.Error("Token missing token ID claim")
+	}
 }
 
 func TestGenerateTokenExpiration(t *testing.T) {
@@ -124,7 +217,7 @@ func TestGenerateTokenExpiration(t *testing.T) {
 	exp := int64(claims["exp"].(float64))
 	iat := int64(claims["iat"].(float64))
 
-	expectedDuration := int64(24 * time.Hour / time.Second)
+	expectedDuration := int64(AccessTokenTTL() / time.Second)
 	actualDuration := exp - iat
 
 	// Allow 5 second tolerance
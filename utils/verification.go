@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcprz/jwtapp/models"
+)
+
+const (
+	defaultEmailVerificationTTL = 24 * time.Hour
+
+	emailVerificationTokenType = "verify-email+jwt"
+)
+
+// How Login treats accounts whose email address hasn't been verified yet.
+const (
+	// EmailVerificationRequired refuses to log them in.
+	EmailVerificationRequired = "required"
+	// EmailVerificationLimited logs them in with an access token only, no
+	// refresh token, and email_verified set to false.
+	EmailVerificationLimited = "limited"
+	// EmailVerificationOff doesn't send verification mails or restrict
+	// anything.
+	EmailVerificationOff = "off"
+)
+
+// EmailVerificationMode returns EMAIL_VERIFICATION, "limited" by default.
+func EmailVerificationMode() string {
+	switch mode := os.Getenv("EMAIL_VERIFICATION"); mode {
+	case EmailVerificationRequired, EmailVerificationLimited, EmailVerificationOff:
+		return mode
+	case "":
+		return EmailVerificationLimited
+	default:
+		log.Printf("Invalid EMAIL_VERIFICATION value %q, using %s", mode, EmailVerificationLimited)
+		return EmailVerificationLimited
+	}
+}
+
+// EmailVerificationTTL returns how long verification links stay valid,
+// configurable through EMAIL_VERIFICATION_TTL (e.g. "24h").
+func EmailVerificationTTL() time.Duration {
+	return durationFromEnv("EMAIL_VERIFICATION_TTL", defaultEmailVerificationTTL)
+}
+
+// GenerateEmailVerificationToken returns a signed token proving that whoever
+// holds it received mail at the user's current address. Its jti is returned
+// too, so the caller can make the token single-use.
+func GenerateEmailVerificationToken(user models.User) (string, string, error) {
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := signTypedToken(jwt.MapClaims{
+		"sub":   strconv.Itoa(user.ID),
+		"email": user.Email,
+		"jti":   jti,
+		"iss":   Issuer(),
+		"exp":   time.Now().Add(EmailVerificationTTL()).Unix(),
+		"iat":   time.Now().Unix(),
+	}, emailVerificationTokenType)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, jti, nil
+}
+
+// ParseEmailVerificationToken checks a token made by
+// GenerateEmailVerificationToken and returns its claims.
+func ParseEmailVerificationToken(tokenStr string) (jwt.MapClaims, error) {
+	return parseTypedToken(tokenStr, emailVerificationTokenType)
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jcprz/jwtapp/models"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	os.Setenv("SECRET", "test-secret-key")
+	defer os.Unsetenv("SECRET")
+
+	user := models.User{ID: 1, Email: "test@example.com"}
+
+	token, jti, err := GenerateEmailVerificationToken(user)
+	if err != nil {
+		t.Fatalf("GenerateEmailVerificationToken() returned error: %v", err)
+	}
+
+	claims, err := ParseEmailVerificationToken(token)
+	if err != nil {
+		t.Fatalf("ParseEmailVerificationToken() returned error: %v", err)
+	}
+
+	if claims["sub"] != "1" || claims["email"] != user.Email || claims["jti"] != jti {
+		t.Errorf("Unexpected claims %v", claims)
+	}
+
+	if _, err := ParseToken(token); err == nil {
+		t.Error("Expected ParseToken() to reject a verification token")
+	}
+
+	accessToken, _ := GenerateToken(user)
+	if _, err := ParseEmailVerificationToken(accessToken); err == nil {
+		t.Error("Expected ParseEmailVerificationToken() to reject an access token")
+	}
+}
+
+func TestEmailVerificationMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"", EmailVerificationLimited},
+		{"required", EmailVerificationRequired},
+		{"off", EmailVerificationOff},
+		{"sometimes", EmailVerificationLimited},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("EMAIL_VERIFICATION", tt.value)
+		if mode := EmailVerificationMode(); mode != tt.expected {
+			t.Errorf("EmailVerificationMode() with %q = %s, expected %s", tt.value, mode, tt.expected)
+		}
+	}
+	os.Unsetenv("EMAIL_VERIFICATION")
+}
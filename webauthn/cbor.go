@@ -0,0 +1,157 @@
+package webauthn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cborMaxDepth bounds how deeply arrays and maps may nest. Nothing WebAuthn
+// sends comes close.
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the data item at the start of data and returns it along
+// with the number of bytes it took up. Only what authenticators produce is
+// supported: integers (as int64), byte strings, text strings, arrays, maps
+// keyed by integers or strings, booleans and null. Indefinite lengths, tags
+// and floats are rejected.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data: data}
+
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return v, d.off, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// head reads the initial byte of a data item and its argument.
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, 0, errCBORTruncated
+	}
+
+	b := d.data[d.off]
+	d.off++
+
+	major, info := b>>5, b&0x1f
+	if info < 24 {
+		return major, info, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, 0, fmt.Errorf("cbor: unsupported additional information %d", info)
+	}
+
+	n := 1 << (info - 24)
+	if len(d.data)-d.off < n {
+		return 0, 0, 0, errCBORTruncated
+	}
+
+	var arg uint64
+	for _, c := range d.data[d.off : d.off+n] {
+		arg = arg<<8 | uint64(c)
+	}
+	d.off += n
+
+	return major, info, arg, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: nested too deeply")
+	}
+
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflows int64")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errCBORTruncated
+		}
+		b := d.data[d.off : d.off+int(arg)]
+		d.off += int(arg)
+
+		if major == 2 {
+			return append([]byte(nil), b...), nil
+		}
+		if !utf8.Valid(b) {
+			return nil, errors.New("cbor: invalid UTF-8 in text string")
+		}
+		return string(b), nil
+	case 4:
+		// Every item takes at least one byte, which keeps a bogus length from
+		// allocating more than the input could hold.
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.off)/2 {
+			return nil, errCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			if _, ok := m[key]; ok {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", key)
+			}
+
+			value, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value or float %d", info)
+	default:
+		return nil, fmt.Errorf("cbor: unsupported major type %d", major)
+	}
+}
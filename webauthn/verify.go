@@ -0,0 +1,265 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrSignCount is returned for an assertion whose signature counter did not
+// go up, a sign the authenticator may have been cloned.
+var ErrSignCount = errors.New("signature counter did not increase")
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+	flagExtensions         = 0x80
+)
+
+// maxCredentialIDLength is the longest credential ID WebAuthn allows.
+const maxCredentialIDLength = 1023
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Challenge returns the challenge a response was made for, so the ceremony it
+// belongs to can be looked up before verifying it.
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("invalid client data: %w", err)
+	}
+
+	challenge, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, errors.New("invalid challenge in client data")
+	}
+
+	return challenge, nil
+}
+
+func (rp RelyingParty) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("invalid client data: %w", err)
+	}
+
+	if cd.Type != typ {
+		return fmt.Errorf("client data type is %q, expected %q", cd.Type, typ)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return errors.New("challenge mismatch")
+	}
+
+	if cd.CrossOrigin {
+		return errors.New("cross-origin requests are not allowed")
+	}
+
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin %q is not allowed", cd.Origin)
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// Only set when flagAttestedCredential is.
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("authenticator data too short")
+	}
+
+	ad := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.flags&flagAttestedCredential != 0 {
+		if len(rest) < 18 {
+			return authenticatorData{}, errors.New("attested credential data too short")
+		}
+
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if idLen > maxCredentialIDLength || len(rest) < idLen {
+			return authenticatorData{}, errors.New("invalid credential ID")
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("invalid credential public key: %w", err)
+		}
+		ad.publicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if ad.flags&flagExtensions != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("invalid extensions: %w", err)
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return authenticatorData{}, errors.New("trailing data after authenticator data")
+	}
+
+	return ad, nil
+}
+
+func (rp RelyingParty) verifyAuthenticatorData(ad authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return errors.New("RP ID mismatch")
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return errors.New("user was not present")
+	}
+
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return errors.New("user was not verified")
+	}
+
+	return nil
+}
+
+// VerifyRegistration checks a newly created credential against the challenge
+// it was created for and returns what needs to be stored. With requireUV the
+// authenticator must have verified the user, e.g. with a PIN or biometrics.
+func (rp RelyingParty) VerifyRegistration(resp RegistrationResponse, challenge []byte, requireUV bool) (Credential, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, fmt.Errorf("unexpected credential type %q", resp.Type)
+	}
+
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	v, n, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	if n != len(resp.Response.AttestationObject) {
+		return Credential{}, errors.New("trailing data after attestation object")
+	}
+
+	attestation, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, errors.New("attestation object is not a map")
+	}
+
+	// The statement itself is ignored, see the package documentation.
+	if _, ok := attestation["fmt"].(string); !ok {
+		return Credential{}, errors.New("attestation object has no format")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("attestation object has no authenticator data")
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if err := rp.verifyAuthenticatorData(ad, requireUV); err != nil {
+		return Credential{}, err
+	}
+
+	if ad.flags&flagAttestedCredential == 0 {
+		return Credential{}, errors.New("no attested credential data")
+	}
+
+	if !bytes.Equal(ad.credentialID, resp.RawID) {
+		return Credential{}, errors.New("credential ID mismatch")
+	}
+
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:         append([]byte(nil), ad.credentialID...),
+		PublicKey:  append([]byte(nil), ad.publicKey...),
+		SignCount:  ad.signCount,
+		Transports: resp.Response.Transports,
+		AAGUID:     append([]byte(nil), ad.aaguid...),
+	}, nil
+}
+
+// VerifyAssertion checks an assertion made with the stored credential against
+// the challenge it was made for, and returns the new signature counter to
+// store. Checking the user handle is up to the caller.
+func (rp RelyingParty) VerifyAssertion(resp AssertionResponse, challenge []byte, credential Credential, requireUV bool) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, fmt.Errorf("unexpected credential type %q", resp.Type)
+	}
+
+	if !bytes.Equal(resp.RawID, credential.ID) {
+		return 0, errors.New("credential ID mismatch")
+	}
+
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := rp.verifyAuthenticatorData(ad, requireUV); err != nil {
+		return 0, err
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+
+	if err := key.verify(signed, resp.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't count, like most passkeys, always send 0.
+	if (ad.signCount != 0 || credential.SignCount != 0) && ad.signCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return ad.signCount, nil
+}
@@ -0,0 +1,477 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+// cborMap keeps its pairs in order so encoded test data is deterministic.
+type cborMap []cborPair
+
+type cborPair struct {
+	key, value interface{}
+}
+
+func encodeCBOR(v interface{}) []byte {
+	var buf bytes.Buffer
+	writeCBOR(&buf, v)
+	return buf.Bytes()
+}
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= 0xffffffff:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func writeCBOR(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case int:
+		if v >= 0 {
+			writeCBORHead(buf, 0, uint64(v))
+		} else {
+			writeCBORHead(buf, 1, uint64(-1-v))
+		}
+	case []byte:
+		writeCBORHead(buf, 2, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		writeCBORHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeCBORHead(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			writeCBOR(buf, item)
+		}
+	case cborMap:
+		writeCBORHead(buf, 5, uint64(len(v)))
+		for _, pair := range v {
+			writeCBOR(buf, pair.key)
+			writeCBOR(buf, pair.value)
+		}
+	default:
+		panic("unsupported CBOR test value")
+	}
+}
+
+// softAuthenticator is a software stand-in for a security key or platform
+// authenticator, doing what the browser and authenticator do together.
+type softAuthenticator struct {
+	origin       string
+	signer       crypto.Signer
+	hash         crypto.Hash
+	coseKey      []byte
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+
+	// counting makes the authenticator bump its signature counter, which
+	// passkeys don't do.
+	counting     bool
+	userVerified bool
+}
+
+func newSoftAuthenticator(t *testing.T, alg int, origin string) *softAuthenticator {
+	t.Helper()
+
+	a := &softAuthenticator{origin: origin, counting: true, userVerified: true}
+
+	a.credentialID = make([]byte, 16)
+	rand.Read(a.credentialID)
+
+	switch alg {
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Unable to generate key: %v", err)
+		}
+		pub, err := key.PublicKey.ECDH()
+		if err != nil {
+			t.Fatalf("Unable to convert key: %v", err)
+		}
+		point := pub.Bytes()
+
+		a.signer, a.hash = key, crypto.SHA256
+		a.coseKey = encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2},
+			{coseKeyAlg, AlgES256},
+			{coseKeyCurve, coseCurveP256},
+			{coseKeyX, point[1:33]},
+			{coseKeyY, point[33:]},
+		})
+	case AlgEdDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Unable to generate key: %v", err)
+		}
+
+		a.signer = key
+		a.coseKey = encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeOKP},
+			{coseKeyAlg, AlgEdDSA},
+			{coseKeyCurve, coseCurveEd25519},
+			{coseKeyX, []byte(pub)},
+		})
+	default:
+		t.Fatalf("Unsupported algorithm %d", alg)
+	}
+
+	return a
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	flags := byte(flagUserPresent)
+	if a.userVerified {
+		flags |= flagUserVerified
+	}
+	if attested {
+		flags |= flagAttestedCredential
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey...)
+	}
+
+	return data
+}
+
+func (a *softAuthenticator) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	digest := data
+	if a.hash != 0 {
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	}
+
+	sig, err := a.signer.Sign(rand.Reader, digest, a.hash)
+	if err != nil {
+		t.Fatalf("Unable to sign: %v", err)
+	}
+
+	return sig
+}
+
+// create plays navigator.credentials.create() and returns the response the
+// way the server receives it, after a trip through JSON.
+func (a *softAuthenticator) create(t *testing.T, options CreationOptions) RegistrationResponse {
+	a.userHandle = options.User.ID
+
+	resp := RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+		Response: AuthenticatorAttestationResponse{
+			ClientDataJSON: a.clientData("webauthn.create", options.Challenge),
+			AttestationObject: encodeCBOR(cborMap{
+				{"fmt", "none"},
+				{"attStmt", cborMap{}},
+				{"authData", a.authenticatorData(options.RP.ID, true)},
+			}),
+			Transports: []string{"internal"},
+		},
+	}
+
+	var received RegistrationResponse
+	roundTrip(t, resp, &received)
+	return received
+}
+
+// get plays navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, options RequestOptions) AssertionResponse {
+	if a.counting {
+		a.signCount++
+	}
+
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+	authData := a.authenticatorData(options.RPID, false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	resp := AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+		Response: AuthenticatorAssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authData,
+			Signature:         a.sign(t, append(authData, clientDataHash[:]...)),
+			UserHandle:        a.userHandle,
+		},
+	}
+
+	var received AssertionResponse
+	roundTrip(t, resp, &received)
+	return received
+}
+
+func roundTrip(t *testing.T, in, out interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Unable to marshal: %v", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("Unable to unmarshal: %v", err)
+	}
+}
+
+var testRP = RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func register(t *testing.T, authenticator *softAuthenticator) Credential {
+	t.Helper()
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatalf("NewChallenge() returned error: %v", err)
+	}
+
+	options := testRP.CreationOptions(challenge, UserEntity{ID: []byte("42"), Name: "test@example.com", DisplayName: "test@example.com"}, nil)
+	credential, err := testRP.VerifyRegistration(authenticator.create(t, options), challenge, true)
+	if err != nil {
+		t.Fatalf("VerifyRegistration() returned error: %v", err)
+	}
+
+	return credential
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for _, alg := range []int{AlgES256, AlgEdDSA} {
+		authenticator := newSoftAuthenticator(t, alg, "https://example.com")
+
+		credential := register(t, authenticator)
+		if !bytes.Equal(credential.ID, authenticator.credentialID) || !bytes.Equal(credential.PublicKey, authenticator.coseKey) {
+			t.Fatalf("alg %d: unexpected credential %+v", alg, credential)
+		}
+		if len(credential.Transports) != 1 || credential.Transports[0] != "internal" {
+			t.Errorf("alg %d: expected transports [internal], got %v", alg, credential.Transports)
+		}
+
+		for i := 1; i <= 2; i++ {
+			challenge, _ := NewChallenge()
+			options := testRP.RequestOptions(challenge, []CredentialDescriptor{credential.Descriptor()}, UserVerificationRequired)
+
+			assertion := authenticator.get(t, options)
+			if !bytes.Equal(assertion.Response.UserHandle, []byte("42")) {
+				t.Errorf("alg %d: unexpected user handle %q", alg, assertion.Response.UserHandle)
+			}
+
+			count, err := testRP.VerifyAssertion(assertion, challenge, credential, true)
+			if err != nil {
+				t.Fatalf("alg %d: VerifyAssertion() returned error: %v", alg, err)
+			}
+			if count != uint32(i) {
+				t.Errorf("alg %d: expected sign count %d, got %d", alg, i, count)
+			}
+
+			credential.SignCount = count
+		}
+	}
+}
+
+func TestVerifyAssertionSignCount(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgES256, "https://example.com")
+	credential := register(t, authenticator)
+
+	challenge, _ := NewChallenge()
+	options := testRP.RequestOptions(challenge, nil, UserVerificationRequired)
+
+	assertion := authenticator.get(t, options)
+	count, err := testRP.VerifyAssertion(assertion, challenge, credential, true)
+	if err != nil {
+		t.Fatalf("VerifyAssertion() returned error: %v", err)
+	}
+	credential.SignCount = count
+
+	// A clone of the authenticator would still be at the old count.
+	if _, err := testRP.VerifyAssertion(assertion, challenge, credential, true); err != ErrSignCount {
+		t.Errorf("Expected ErrSignCount for a repeated counter, got %v", err)
+	}
+
+	// Authenticators without a counter always send 0, which is fine.
+	passkey := newSoftAuthenticator(t, AlgEdDSA, "https://example.com")
+	passkey.counting = false
+	credential = register(t, passkey)
+
+	for i := 0; i < 2; i++ {
+		if _, err := testRP.VerifyAssertion(passkey.get(t, options), challenge, credential, true); err != nil {
+			t.Errorf("VerifyAssertion() returned error for a passkey without a counter: %v", err)
+		}
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	challenge, _ := NewChallenge()
+	user := UserEntity{ID: []byte("42"), Name: "test@example.com", DisplayName: "test@example.com"}
+
+	tests := []struct {
+		name      string
+		origin    string
+		rpID      string
+		uv        bool
+		challenge []byte
+		mutate    func(*RegistrationResponse)
+	}{
+		{name: "wrong origin", origin: "https://evil.example", rpID: "example.com", uv: true, challenge: challenge},
+		{name: "wrong RP ID", origin: "https://example.com", rpID: "evil.example", uv: true, challenge: challenge},
+		{name: "wrong challenge", origin: "https://example.com", rpID: "example.com", uv: true, challenge: []byte("other challenge")},
+		{name: "user not verified", origin: "https://example.com", rpID: "example.com", uv: false, challenge: challenge},
+		{name: "credential ID mismatch", origin: "https://example.com", rpID: "example.com", uv: true, challenge: challenge,
+			mutate: func(resp *RegistrationResponse) { resp.RawID = []byte("other id") }},
+		{name: "truncated attestation object", origin: "https://example.com", rpID: "example.com", uv: true, challenge: challenge,
+			mutate: func(resp *RegistrationResponse) {
+				resp.Response.AttestationObject = resp.Response.AttestationObject[:len(resp.Response.AttestationObject)-1]
+			}},
+	}
+
+	for _, tt := range tests {
+		authenticator := newSoftAuthenticator(t, AlgES256, tt.origin)
+		authenticator.userVerified = tt.uv
+
+		options := testRP.CreationOptions(tt.challenge, user, nil)
+		options.RP.ID = tt.rpID
+
+		resp := authenticator.create(t, options)
+		if tt.mutate != nil {
+			tt.mutate(&resp)
+		}
+
+		if _, err := testRP.VerifyRegistration(resp, challenge, true); err == nil {
+			t.Errorf("%s: expected VerifyRegistration() to fail", tt.name)
+		}
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgES256, "https://example.com")
+	credential := register(t, authenticator)
+	other := register(t, newSoftAuthenticator(t, AlgES256, "https://example.com"))
+
+	challenge, _ := NewChallenge()
+	options := testRP.RequestOptions(challenge, nil, UserVerificationRequired)
+
+	assertion := authenticator.get(t, options)
+
+	tampered := authenticator.get(t, options)
+	tampered.Response.Signature[len(tampered.Response.Signature)-1] ^= 0xff
+	if _, err := testRP.VerifyAssertion(tampered, challenge, credential, true); err == nil {
+		t.Error("Expected a tampered signature to be rejected")
+	}
+
+	other.ID = credential.ID
+	if _, err := testRP.VerifyAssertion(assertion, challenge, other, true); err == nil {
+		t.Error("Expected a signature by another key to be rejected")
+	}
+
+	if _, err := testRP.VerifyAssertion(assertion, []byte("other challenge"), credential, true); err == nil {
+		t.Error("Expected a wrong challenge to be rejected")
+	}
+
+	registration := authenticator.create(t, testRP.CreationOptions(challenge, UserEntity{ID: []byte("42")}, nil))
+	asAssertion := assertion
+	asAssertion.Response.ClientDataJSON = registration.Response.ClientDataJSON
+	if _, err := testRP.VerifyAssertion(asAssertion, challenge, credential, true); err == nil {
+		t.Error("Expected client data of a registration to be rejected")
+	}
+
+	authenticator.userVerified = false
+	if _, err := testRP.VerifyAssertion(authenticator.get(t, options), challenge, credential, true); err == nil {
+		t.Error("Expected an assertion without user verification to be rejected")
+	}
+	if _, err := testRP.VerifyAssertion(authenticator.get(t, options), challenge, credential, false); err != nil {
+		t.Errorf("Expected an assertion without user verification to pass as a second factor, got %v", err)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, AlgES256, "https://example.com")
+
+	challenge, _ := NewChallenge()
+	got, err := Challenge(authenticator.clientData("webauthn.get", challenge))
+	if err != nil || !bytes.Equal(got, challenge) {
+		t.Errorf("Challenge() = %x, %v; expected %x", got, err, challenge)
+	}
+
+	if _, err := Challenge([]byte(`{"type":"webauthn.get"}`)); err == nil {
+		t.Error("Expected an error for client data without a challenge")
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	data := encodeCBOR(cborMap{
+		{1, 2},
+		{-3, []byte{0xaa}},
+		{"list", []interface{}{-1000, "x"}},
+	})
+
+	v, n, err := decodeCBOR(append(data, 0xff))
+	if err != nil {
+		t.Fatalf("decodeCBOR() returned error: %v", err)
+	}
+	if n != len(data) {
+		t.Errorf("Expected %d bytes to be read, got %d", len(data), n)
+	}
+
+	m := v.(map[interface{}]interface{})
+	if m[int64(1)] != int64(2) || !bytes.Equal(m[int64(-3)].([]byte), []byte{0xaa}) {
+		t.Errorf("Unexpected map %v", m)
+	}
+	if list := m["list"].([]interface{}); list[0] != int64(-1000) || list[1] != "x" {
+		t.Errorf("Unexpected list %v", list)
+	}
+
+	invalid := map[string][]byte{
+		"truncated":          data[:len(data)-1],
+		"indefinite length":  {0x9f, 0x01, 0xff},
+		"duplicate keys":     {0xa2, 0x01, 0x01, 0x01, 0x02},
+		"float":              {0xfa, 0x00, 0x00, 0x00, 0x00},
+		"tag":                {0xc0, 0x01},
+		"huge array":         {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"invalid UTF-8 text": {0x61, 0xff},
+	}
+	for name, data := range invalid {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: expected decodeCBOR() to fail", name)
+		}
+	}
+}